@@ -21,6 +21,9 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/intelsdi-x/snap-plugin-publisher-mysql/mysql"
 	"github.com/intelsdi-x/snap/control/plugin"
@@ -28,5 +31,20 @@ import (
 
 func main() {
 	meta := mysql.Meta()
-	plugin.Start(meta, mysql.NewMySQLPublisher(), os.Args[1])
+	publisher := mysql.NewMySQLPublisher()
+
+	// flush metrics queued in async mode before the plugin exits, plugin.Start returns when snapd kills the
+	// plugin, while a plugin terminated with a signal, e.g. when snapd itself is stopped, exits right away
+	var closeOnce sync.Once
+	closePublisher := func() { closeOnce.Do(func() { publisher.Close() }) }
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		closePublisher()
+		os.Exit(0)
+	}()
+
+	plugin.Start(meta, publisher, os.Args[1])
+	closePublisher()
 }
@@ -0,0 +1,175 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+)
+
+// queueBlock blocks Publish until the queue has room for the metrics, it's the other queue_full policy next to
+// evictOldest and evictNewest
+const queueBlock = "block"
+
+// errWriterClosed is returned for metrics published after the plugin started to shut down
+var errWriterClosed = errors.New("Cannot queue metrics, the publisher is shutting down")
+
+func checkQueueFull(policy string) error {
+	switch policy {
+	case queueBlock, evictOldest, evictNewest:
+		return nil
+	}
+	return fmt.Errorf("Unknown queue_full '%s', supported values: %s, %s, %s", policy, queueBlock, evictOldest, evictNewest)
+}
+
+// asyncWriter queues published metrics in a bounded queue which a background goroutine flushes when it holds
+// flushSize metrics or flushInterval elapsed
+type asyncWriter struct {
+	mu sync.Mutex
+	// room is signalled when the queue is flushed or the writer is closed
+	room   *sync.Cond
+	queue  []plugin.MetricType
+	closed bool
+
+	size          int
+	flushSize     int
+	flushInterval time.Duration
+	policy        string
+	flush         func([]plugin.MetricType)
+
+	// wake starts a flush before flushInterval elapses, done is closed when the last metrics are flushed
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newAsyncWriter(size, flushSize int, flushInterval time.Duration, policy string, flush func([]plugin.MetricType)) *asyncWriter {
+	w := &asyncWriter{
+		size:          size,
+		flushSize:     flushSize,
+		flushInterval: flushInterval,
+		policy:        policy,
+		flush:         flush,
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	w.room = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// enqueue adds metrics to the queue, when it's full they're handled according to the queue_full policy
+func (w *asyncWriter) enqueue(metrics []plugin.MetricType) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dropped := 0
+	for len(metrics) > 0 {
+		if w.closed {
+			return errWriterClosed
+		}
+		free := w.size - len(w.queue)
+		switch {
+		case free >= len(metrics):
+			w.queue = append(w.queue, metrics...)
+			metrics = nil
+		case w.policy == evictNewest:
+			w.queue = append(w.queue, metrics[:free]...)
+			dropped += len(metrics) - free
+			metrics = nil
+		case w.policy == evictOldest:
+			if len(metrics) > w.size {
+				dropped += len(metrics) - w.size
+				metrics = metrics[len(metrics)-w.size:]
+			}
+			n := len(w.queue) + len(metrics) - w.size
+			if n > 0 {
+				dropped += n
+				w.queue = append(w.queue[:0], w.queue[n:]...)
+			}
+			w.queue = append(w.queue, metrics...)
+			metrics = nil
+		default:
+			// queue what fits and wait for a flush to make room for the rest
+			w.queue = append(w.queue, metrics[:free]...)
+			metrics = metrics[free:]
+			w.signal()
+			w.room.Wait()
+		}
+	}
+	if len(w.queue) >= w.flushSize {
+		w.signal()
+	}
+	if dropped > 0 {
+		log.New().Printf("Error: queue of %d metrics is full, dropped %d metrics (queue_full %s)", w.size, dropped, w.policy)
+	}
+	return nil
+}
+
+// signal wakes the background goroutine up, it's called with mu held
+func (w *asyncWriter) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run flushes the queue until the writer is closed, then flushes the metrics left
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.wake:
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		metrics, closed := w.queue, w.closed
+		w.queue = nil
+		w.room.Broadcast()
+		w.mu.Unlock()
+
+		if len(metrics) > 0 {
+			w.flush(metrics)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// close stops accepting metrics and returns when the queued ones are flushed
+func (w *asyncWriter) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		w.room.Broadcast()
+		w.signal()
+	}
+	w.mu.Unlock()
+	<-w.done
+}
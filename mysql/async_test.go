@@ -0,0 +1,138 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testFlusher records the metrics flushed by an async writer, a flush blocks until release is closed
+type testFlusher struct {
+	flushed chan []string
+	release chan struct{}
+}
+
+func newTestFlusher() *testFlusher {
+	f := &testFlusher{flushed: make(chan []string, 100), release: make(chan struct{})}
+	close(f.release)
+	return f
+}
+
+func (f *testFlusher) flush(metrics []plugin.MetricType) {
+	<-f.release
+	f.flushed <- spooledNames(metrics)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	Convey("Write metrics in the background", t, func() {
+		f := newTestFlusher()
+
+		Convey("So metrics should be flushed when flush_size metrics are queued", func() {
+			w := newAsyncWriter(10, 3, time.Hour, queueBlock, f.flush)
+			defer w.close()
+			So(w.enqueue(spoolTestMetrics("a", "b")), ShouldBeNil)
+			So(f.flushed, ShouldBeEmpty)
+			So(w.enqueue(spoolTestMetrics("c")), ShouldBeNil)
+			So(<-f.flushed, ShouldResemble, []string{"a", "b", "c"})
+		})
+		Convey("So metrics should be flushed when flush_interval elapses", func() {
+			w := newAsyncWriter(10, 5, 10*time.Millisecond, queueBlock, f.flush)
+			defer w.close()
+			So(w.enqueue(spoolTestMetrics("a")), ShouldBeNil)
+			So(<-f.flushed, ShouldResemble, []string{"a"})
+		})
+		Convey("So closing should flush the queued metrics and reject new ones", func() {
+			w := newAsyncWriter(10, 5, time.Hour, queueBlock, f.flush)
+			So(w.enqueue(spoolTestMetrics("a", "b")), ShouldBeNil)
+			w.close()
+			So(<-f.flushed, ShouldResemble, []string{"a", "b"})
+			So(w.enqueue(spoolTestMetrics("c")), ShouldEqual, errWriterClosed)
+		})
+
+		Convey("When the queue is full", func() {
+			// hold the first flush, so that the queue fills up
+			f.release = make(chan struct{})
+			Convey("So the oldest metrics should be dropped with drop_oldest", func() {
+				w := newAsyncWriter(3, 1, time.Hour, evictOldest, f.flush)
+				So(w.enqueue(spoolTestMetrics("a")), ShouldBeNil)
+				waitForEmptyQueue(w)
+				So(w.enqueue(spoolTestMetrics("b", "c")), ShouldBeNil)
+				So(w.enqueue(spoolTestMetrics("d", "e")), ShouldBeNil)
+				close(f.release)
+				w.close()
+				So(<-f.flushed, ShouldResemble, []string{"a"})
+				So(<-f.flushed, ShouldResemble, []string{"c", "d", "e"})
+			})
+			Convey("So the newest metrics should be dropped with drop_newest", func() {
+				w := newAsyncWriter(3, 1, time.Hour, evictNewest, f.flush)
+				So(w.enqueue(spoolTestMetrics("a")), ShouldBeNil)
+				waitForEmptyQueue(w)
+				So(w.enqueue(spoolTestMetrics("b", "c")), ShouldBeNil)
+				So(w.enqueue(spoolTestMetrics("d", "e")), ShouldBeNil)
+				close(f.release)
+				w.close()
+				So(<-f.flushed, ShouldResemble, []string{"a"})
+				So(<-f.flushed, ShouldResemble, []string{"b", "c", "d"})
+			})
+			Convey("So publishing should wait for room with block", func() {
+				w := newAsyncWriter(3, 1, time.Hour, queueBlock, f.flush)
+				So(w.enqueue(spoolTestMetrics("a")), ShouldBeNil)
+				waitForEmptyQueue(w)
+				So(w.enqueue(spoolTestMetrics("b", "c", "d")), ShouldBeNil)
+				queued := make(chan error)
+				go func() { queued <- w.enqueue(spoolTestMetrics("e")) }()
+				select {
+				case <-queued:
+					So("enqueue returned", ShouldEqual, "enqueue blocked")
+				case <-time.After(20 * time.Millisecond):
+				}
+				close(f.release)
+				So(<-queued, ShouldBeNil)
+				w.close()
+				So(<-f.flushed, ShouldResemble, []string{"a"})
+				So(<-f.flushed, ShouldResemble, []string{"b", "c", "d"})
+				So(<-f.flushed, ShouldResemble, []string{"e"})
+			})
+		})
+		Convey("So an unknown queue_full should return an error", func() {
+			So(checkQueueFull(queueBlock), ShouldBeNil)
+			So(checkQueueFull("drop_all"), ShouldNotBeNil)
+		})
+	})
+}
+
+// waitForEmptyQueue waits until the background goroutine took the queued metrics
+func waitForEmptyQueue(w *asyncWriter) {
+	for {
+		w.mu.Lock()
+		n := len(w.queue)
+		w.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// autoCreateAll creates the database and tables when they're missing
+	autoCreateAll = "all"
+	// autoCreateTable creates missing tables, the database must exist
+	autoCreateTable = "table"
+	// autoCreateNone creates nothing, the database and tables must exist
+	autoCreateNone = "none"
+
+	// errUnknownDatabase is the MySQL server error number returned for a missing database
+	errUnknownDatabase = 1049
+)
+
+// columnsQuery returns the columns of a table with their types and whether they must be written
+const columnsQuery = "SELECT COLUMN_NAME, DATA_TYPE, IS_NULLABLE = 'NO' AND COLUMN_DEFAULT IS NULL AND EXTRA NOT LIKE '%auto_increment%' AND EXTRA NOT LIKE '%GENERATED%' " +
+	"FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"
+
+func checkAutoCreate(mode string) error {
+	switch mode {
+	case autoCreateAll, autoCreateTable, autoCreateNone:
+		return nil
+	}
+	return fmt.Errorf("Unknown auto_create '%s', supported values: %s, %s, %s", mode, autoCreateAll, autoCreateTable, autoCreateNone)
+}
+
+func unknownDatabase(err error) bool {
+	e, ok := err.(*mysqldriver.MySQLError)
+	return ok && e.Number == errUnknownDatabase
+}
+
+// existingColumn is a column of an existing table
+type existingColumn struct {
+	name     string
+	dataType string
+	// required is set for NOT NULL columns without a default value
+	required bool
+}
+
+// prepareTable creates table with createQuery when auto_create allows it and checks that it has the given columns
+func (conn *connection) prepareTable(c *config, table, createQuery string, columns []column) error {
+	if err := conn.createTable(c, table, createQuery); err != nil {
+		return err
+	}
+	return conn.checkTable(c, table, columns)
+}
+
+// createTable creates table with createQuery when auto_create allows it
+func (conn *connection) createTable(c *config, table, createQuery string) error {
+	if c.autoCreate == autoCreateNone {
+		return nil
+	}
+	if _, err := conn.db.Exec(createQuery); err != nil {
+		log.New().Printf("Error: cannot create table %v, err=%v", qualifiedName(c.database, table), err)
+		return err
+	}
+	return nil
+}
+
+// checkTable checks that table has the given columns
+func (conn *connection) checkTable(c *config, table string, columns []column) error {
+	logger := log.New()
+	existing, err := existingColumns(conn.db, c.database, table)
+	if err != nil {
+		logger.Printf("Error: cannot read columns of table %v, err=%v", qualifiedName(c.database, table), err)
+		return err
+	}
+	if err := checkColumns(qualifiedName(c.database, table), existing, columns); err != nil {
+		logger.Printf("Error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// existingColumns returns the columns of table, none when it doesn't exist
+func existingColumns(db *sql.DB, database, table string) ([]existingColumn, error) {
+	rows, err := db.Query(columnsQuery, database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var existing []existingColumn
+	for rows.Next() {
+		var col existingColumn
+		var required sql.NullBool
+		if err := rows.Scan(&col.name, &col.dataType, &required); err != nil {
+			return nil, err
+		}
+		col.required = required.Bool
+		existing = append(existing, col)
+	}
+	return existing, rows.Err()
+}
+
+// checkColumns verifies that the existing columns of table can store the columns written by the publisher
+func checkColumns(table string, existing []existingColumn, columns []column) error {
+	if len(existing) == 0 {
+		return fmt.Errorf("Table %s does not exist", table)
+	}
+
+	byName := map[string]existingColumn{}
+	for _, col := range existing {
+		byName[strings.ToLower(col.name)] = col
+	}
+	written := map[string]bool{}
+	var problems []string
+	for _, col := range columns {
+		written[strings.ToLower(col.name)] = true
+		e, ok := byName[strings.ToLower(col.name)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("column %s is missing, expected %s", col.name, col.definition))
+			continue
+		}
+		if expected := typeFamily(col.definition); !compatibleType(expected, typeFamily(e.dataType)) {
+			problems = append(problems, fmt.Sprintf("column %s is %s, expected %s", col.name, strings.ToUpper(e.dataType), col.definition))
+		}
+	}
+	for _, col := range existing {
+		if col.required && !written[strings.ToLower(col.name)] {
+			problems = append(problems, fmt.Sprintf("column %s is NOT NULL without a default value, but the publisher doesn't write it", col.name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Table %s is not compatible with the publisher: %s", table, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// typeFamily returns the group of MySQL types able to store the same values as the type of a column definition
+func typeFamily(definition string) string {
+	t := strings.ToLower(definition)
+	if i := strings.IndexAny(t, "( "); i >= 0 {
+		t = t[:i]
+	}
+	switch t {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return "string"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "binary"
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return "integer"
+	case "float", "double", "decimal", "real":
+		return "number"
+	case "datetime", "timestamp":
+		return "datetime"
+	}
+	return t
+}
+
+func compatibleType(expected, actual string) bool {
+	// MariaDB stores JSON columns as LONGTEXT
+	return expected == actual || expected == "json" && actual == "string"
+}
@@ -0,0 +1,92 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckColumns(t *testing.T) {
+	Convey("Check the layout of an existing table", t, func() {
+		l, _ := newLayout(schemaTyped, tagsNone)
+		typed := []existingColumn{
+			{name: "id", dataType: "bigint"},
+			{name: "timestamp", dataType: "datetime", required: true},
+			{name: "source_column", dataType: "varchar", required: true},
+			{name: "key_column", dataType: "varchar", required: true},
+			{name: "value_numeric", dataType: "double"},
+			{name: "value_column", dataType: "text"},
+		}
+
+		Convey("So a table created by the publisher should be compatible", func() {
+			So(checkColumns("`snap`.`info`", typed, l.insertColumns()), ShouldBeNil)
+		})
+		Convey("So wider types and extra nullable columns should be compatible", func() {
+			existing := append([]existingColumn{}, typed...)
+			existing[4].dataType = "decimal"
+			existing[5].dataType = "longtext"
+			existing = append(existing, existingColumn{name: "comment", dataType: "text"})
+			So(checkColumns("`snap`.`info`", existing, l.insertColumns()), ShouldBeNil)
+		})
+		Convey("So a missing table should return an error", func() {
+			err := checkColumns("`snap`.`info`", nil, l.insertColumns())
+			So(err.Error(), ShouldEqual, "Table `snap`.`info` does not exist")
+		})
+		Convey("So every incompatible column should be reported", func() {
+			legacy := []existingColumn{
+				{name: "timestamp", dataType: "varchar"},
+				{name: "source_column", dataType: "varchar"},
+				{name: "key_column", dataType: "varchar"},
+				{name: "value_column", dataType: "varchar"},
+				{name: "host_id", dataType: "int", required: true},
+			}
+			err := checkColumns("`snap`.`info`", legacy, l.insertColumns())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Table `snap`.`info` is not compatible with the publisher: "+
+				"column timestamp is VARCHAR, expected DATETIME(6) NOT NULL; "+
+				"column value_numeric is missing, expected DOUBLE NULL; "+
+				"column host_id is NOT NULL without a default value, but the publisher doesn't write it")
+		})
+		Convey("So JSON columns stored as text should be compatible", func() {
+			So(compatibleType(typeFamily("JSON NULL"), typeFamily("longtext")), ShouldBeTrue)
+			So(compatibleType(typeFamily("TEXT NULL"), typeFamily("json")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestAutoCreate(t *testing.T) {
+	Convey("Validate auto_create", t, func() {
+		So(checkAutoCreate(autoCreateAll), ShouldBeNil)
+		So(checkAutoCreate(autoCreateTable), ShouldBeNil)
+		So(checkAutoCreate(autoCreateNone), ShouldBeNil)
+		So(checkAutoCreate("database"), ShouldNotBeNil)
+	})
+	Convey("Create the database only when it's missing", t, func() {
+		So(unknownDatabase(&mysqldriver.MySQLError{Number: 1049}), ShouldBeTrue)
+		So(unknownDatabase(&mysqldriver.MySQLError{Number: 1044, Message: "Access denied"}), ShouldBeFalse)
+		So(unknownDatabase(errors.New("Unknown database")), ShouldBeFalse)
+	})
+}
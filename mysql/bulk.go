@@ -0,0 +1,270 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// bulkReaderPrefix prefixes the names of the readers registered with the driver for LOAD DATA LOCAL INFILE
+	bulkReaderPrefix = "snap-mysql-bulk-"
+	// bulkTimeFormat is the format of timestamps loaded into DATETIME(6) and VARCHAR columns, as the driver sends them to inserts
+	bulkTimeFormat = "2006-01-02 15:04:05.999999"
+	// bulkNull is the value LOAD DATA reads as NULL
+	bulkNull = `\N`
+	// bulkSavepoint marks the beginning of a load within a transaction, so that only the load can be rolled back
+	bulkSavepoint = "snap_bulk_load"
+
+	// errDuplicateEntry is the code of the warning of a row ignored because of a duplicate key
+	errDuplicateEntry = 1062
+)
+
+// loadWarning is a warning raised by a load, as listed by SHOW WARNINGS
+type loadWarning struct {
+	level   string
+	code    int
+	message string
+}
+
+// bulkReaders numbers the readers registered by concurrent loads, so that they don't replace each other
+var bulkReaders uint64
+
+// bulkEscaper escapes the characters LOAD DATA treats as field and line separators or escapes by default
+var bulkEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+// checkBulk validates bulk loading against the schema and the handling of duplicates, LOAD DATA LOCAL ignores
+// duplicate keys and can't update the rows it loads
+func checkBulk(c *config) error {
+	if c.bulkThreshold < 0 {
+		return fmt.Errorf("Invalid bulk_threshold %d, it must not be negative", c.bulkThreshold)
+	}
+	if c.bulkThreshold == 0 {
+		return nil
+	}
+	if c.schema == schemaWide {
+		return fmt.Errorf("Invalid bulk_threshold %d, rows of the %s schema are merged on insert, they can't be loaded", c.bulkThreshold, schemaWide)
+	}
+	if c.uniqueKey && c.onDuplicate != duplicateIgnore {
+		return fmt.Errorf("Invalid bulk_threshold %d, loaded duplicates are always ignored, on_duplicate must be %s", c.bulkThreshold, duplicateIgnore)
+	}
+	return nil
+}
+
+// bulk reports whether rows are many enough to be loaded with LOAD DATA instead of inserted
+func (conn *connection) bulk(rows []row) bool {
+	return conn.bulkThreshold > 0 && len(rows) >= conn.bulkThreshold
+}
+
+// loadQuery returns the statement loading the columns of l from the reader with the given name, duplicates are ignored
+func (l *layout) loadQuery(table, reader string) string {
+	columns := l.insertColumns()
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	ignore := ""
+	if l.onDuplicate == duplicateIgnore {
+		ignore = " IGNORE"
+	}
+	return "LOAD DATA LOCAL INFILE 'Reader::" + reader + "'" + ignore + " INTO TABLE " + table + " CHARACTER SET utf8mb4 (" + strings.Join(names, ", ") + ")"
+}
+
+// bulkValue returns a value bound to an insert as a field of LOAD DATA, times are converted to loc like the driver does
+func bulkValue(v interface{}, loc *time.Location) string {
+	switch v := v.(type) {
+	case nil:
+		return bulkNull
+	case string:
+		return bulkEscaper.Replace(v)
+	case []byte:
+		return bulkEscaper.Replace(string(v))
+	case time.Time:
+		return v.In(loc).Format(bulkTimeFormat)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return bulkEscaper.Replace(fmt.Sprint(v))
+}
+
+// bulkReader returns a reader streaming rows as tab separated lines of the insert columns of l, the rows are encoded
+// while the driver sends them so that they're never held in memory or a file as a whole
+func bulkReader(l *layout, rows []row, loc *time.Location) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		columns := l.insertColumns()
+		bw := bufio.NewWriter(w)
+		var err error
+		for i := 0; i < len(rows) && err == nil; i++ {
+			for j, c := range columns {
+				if j > 0 {
+					bw.WriteByte('\t')
+				}
+				bw.WriteString(bulkValue(c.value(&rows[i]), loc))
+			}
+			// writes to a closed pipe fail, bufio keeps the error until Flush
+			err = bw.WriteByte('\n')
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		w.CloseWithError(err)
+	}()
+	return r
+}
+
+// loadVerified reports whether a load of the given number of rows stored all of them unchanged; LOAD DATA LOCAL turns
+// errors into warnings, so every warning fails the load but duplicates ignored by the unique key. The warnings may be
+// fewer than their total, the ones not listed can't be checked
+func loadVerified(rows int, affected int64, warnings []loadWarning, total int, duplicatesIgnored bool) bool {
+	if total == 0 {
+		return affected == int64(rows)
+	}
+	if !duplicatesIgnored || total > len(warnings) {
+		return false
+	}
+	for _, w := range warnings {
+		if w.code != errDuplicateEntry {
+			return false
+		}
+	}
+	return affected+int64(total) == int64(rows)
+}
+
+// loadWarnings returns the warnings of the last statement of tx and their total, SHOW WARNINGS lists at most
+// max_error_count of them
+func loadWarnings(tx *sql.Tx) ([]loadWarning, int, error) {
+	var total int
+	if err := tx.QueryRow("SHOW COUNT(*) WARNINGS").Scan(&total); err != nil || total == 0 {
+		return nil, total, err
+	}
+	rows, err := tx.Query("SHOW WARNINGS")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var warnings []loadWarning
+	for rows.Next() {
+		var w loadWarning
+		if err := rows.Scan(&w.level, &w.code, &w.message); err != nil {
+			return nil, 0, err
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings, total, rows.Err()
+}
+
+// load executes query within tx and reports whether it stored the given number of rows unchanged
+func (conn *connection) load(tx *sql.Tx, query string, rows int) (bool, error) {
+	res, err := tx.Exec(query)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	warnings, total, err := loadWarnings(tx)
+	if err != nil {
+		return false, err
+	}
+	if !loadVerified(rows, affected, warnings, total, conn.layout.onDuplicate == duplicateIgnore) {
+		first := ""
+		if len(warnings) > 0 {
+			first = warnings[0].message
+		}
+		log.New().Printf("Loading %d rows stored %d of them with %d warnings, first warning: %v", rows, affected, total, first)
+		return false, nil
+	}
+	return true, nil
+}
+
+// loadRolledBack loads rows with query in a transaction of its own, or within the transaction ex behind a savepoint;
+// a load which can't be verified is rolled back and reported as not loaded
+func (conn *connection) loadRolledBack(ex execer, query string, rows int) (bool, error) {
+	switch ex := ex.(type) {
+	case *sql.DB:
+		tx, err := ex.Begin()
+		if err != nil {
+			return false, err
+		}
+		loaded, err := conn.load(tx, query, rows)
+		if err != nil || !loaded {
+			tx.Rollback()
+			return false, err
+		}
+		return true, tx.Commit()
+	case *sql.Tx:
+		if _, err := ex.Exec("SAVEPOINT " + bulkSavepoint); err != nil {
+			return false, err
+		}
+		loaded, err := conn.load(ex, query, rows)
+		if err != nil || loaded {
+			return loaded, err
+		}
+		_, err = ex.Exec("ROLLBACK TO SAVEPOINT " + bulkSavepoint)
+		return false, err
+	}
+	return false, fmt.Errorf("Cannot load rows with %T, it's neither a connection pool nor a transaction", ex)
+}
+
+// loadRows loads rows into d with a single LOAD DATA LOCAL INFILE statement, it returns the number of written rows;
+// loads which can't be verified are rolled back and the rows inserted instead, so that they fail the way inserts do.
+// The driver closes the reader when the statement completes, which stops the goroutine encoding the rows
+func (conn *connection) loadRows(ex execer, d *destination, insertStmt *sql.Stmt, rows []row, retry retryPolicy) (int, error) {
+	name := bulkReaderPrefix + strconv.FormatUint(atomic.AddUint64(&bulkReaders, 1), 10)
+	// every attempt asks the handler for a new reader, streaming the rows from the first one
+	mysqldriver.RegisterReaderHandler(name, func() io.Reader {
+		return bulkReader(conn.layout, rows, conn.loc)
+	})
+	defer mysqldriver.DeregisterReaderHandler(name)
+
+	var loaded bool
+	err := retry.do(func() error {
+		var err error
+		loaded, err = conn.loadRolledBack(ex, conn.layout.loadQuery(d.tableName, name), len(rows))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !loaded {
+		log.New().Printf("Inserting %d rows into table %v which could not be loaded without warnings", len(rows), d.tableName)
+		return conn.insertRows(ex, d, insertStmt, rows, retry)
+	}
+	return len(rows), nil
+}
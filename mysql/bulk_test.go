@@ -0,0 +1,124 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBulk(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 12, 0, 0, 500000000, time.UTC)
+
+	Convey("Validate bulk loading", t, func() {
+		cfg := defaultTestConfig()
+		Convey("So bulk loading should be disabled by default", func() {
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.bulkThreshold, ShouldEqual, 0)
+		})
+		Convey("So a negative threshold should return an error", func() {
+			cfg["bulk_threshold"] = ctypes.ConfigValueInt{Value: -1}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So loading into a wide table should return an error", func() {
+			cfg["bulk_threshold"] = ctypes.ConfigValueInt{Value: 10000}
+			cfg["schema"] = ctypes.ConfigValueStr{Value: schemaWide}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So loading with duplicates updated should return an error", func() {
+			cfg["bulk_threshold"] = ctypes.ConfigValueInt{Value: 10000}
+			cfg["schema"] = ctypes.ConfigValueStr{Value: schemaTyped}
+			cfg["unique_key"] = ctypes.ConfigValueBool{Value: true}
+			cfg["on_duplicate"] = ctypes.ConfigValueStr{Value: duplicateUpdate}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["on_duplicate"] = ctypes.ConfigValueStr{Value: duplicateIgnore}
+			_, err = parseConfig(cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Load rows with LOAD DATA LOCAL INFILE", t, func() {
+		l, err := newLayout(schemaTyped, tagsNone)
+		So(err, ShouldBeNil)
+
+		Convey("So the statement should load the insert columns from a registered reader", func() {
+			So(l.loadQuery("`db`.`info`", "r1"), ShouldEqual, "LOAD DATA LOCAL INFILE 'Reader::r1' INTO TABLE `db`.`info` CHARACTER SET utf8mb4 "+
+				"(timestamp, source_column, key_column, value_numeric, value_column)")
+			l.setUniqueKey(duplicateIgnore)
+			So(l.loadQuery("info", "r1"), ShouldStartWith, "LOAD DATA LOCAL INFILE 'Reader::r1' IGNORE INTO TABLE info")
+		})
+		Convey("So values should be written as fields of LOAD DATA", func() {
+			So(bulkValue(nil, time.UTC), ShouldEqual, `\N`)
+			So(bulkValue("a\tb\nc\\d\re\x00", time.UTC), ShouldEqual, `a\tb\nc\\d\re\0`)
+			So(bulkValue(ts, time.FixedZone("CET", 3600)), ShouldEqual, "2016-10-28 13:00:00.5")
+			So(bulkValue(1.5, time.UTC), ShouldEqual, "1.5")
+			So(bulkValue(math.MaxFloat64, time.UTC), ShouldEqual, "1.7976931348623157e+308")
+			So(bulkValue(int64(-3), time.UTC), ShouldEqual, "-3")
+		})
+		Convey("So rows should be streamed as tab separated lines", func() {
+			rows := []row{
+				{timestamp: ts, source: "host", key: "intel/mock/foo", value: "1", data: 1},
+				{timestamp: ts, source: "host", key: "intel/mock/state", value: "up\tdown", data: "up\tdown"},
+			}
+			b, err := ioutil.ReadAll(bulkReader(l, rows, time.UTC))
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "2016-10-28 12:00:00.5\thost\tintel/mock/foo\t1\t\\N\n"+
+				"2016-10-28 12:00:00.5\thost\tintel/mock/state\t\\N\tup\\tdown\n")
+		})
+		Convey("So a reader closed by the driver should stop streaming", func() {
+			r := bulkReader(l, make([]row, 100000), time.UTC)
+			buf := make([]byte, 16)
+			_, err := r.Read(buf)
+			So(err, ShouldBeNil)
+			So(r.(interface {
+				Close() error
+			}).Close(), ShouldBeNil)
+		})
+		Convey("So rows reaching the threshold should be loaded", func() {
+			conn := &connection{layout: l, batchSize: 2, bulkThreshold: 3}
+			So(conn.bulk(make([]row, 2)), ShouldBeFalse)
+			So(conn.bulk(make([]row, 3)), ShouldBeTrue)
+			conn.bulkThreshold = 0
+			So(conn.bulk(make([]row, 3)), ShouldBeFalse)
+		})
+		Convey("So loads should be verified", func() {
+			truncated := loadWarning{level: "Warning", code: 1265, message: "Data truncated for column 'value_column' at row 1"}
+			duplicate := loadWarning{level: "Warning", code: errDuplicateEntry, message: "Duplicate entry"}
+			So(loadVerified(5, 5, nil, 0, false), ShouldBeTrue)
+			So(loadVerified(5, 4, nil, 0, false), ShouldBeFalse)
+			So(loadVerified(5, 5, []loadWarning{truncated}, 1, false), ShouldBeFalse)
+			So(loadVerified(5, 4, []loadWarning{duplicate}, 1, false), ShouldBeFalse)
+			So(loadVerified(5, 4, []loadWarning{duplicate}, 1, true), ShouldBeTrue)
+			So(loadVerified(5, 3, []loadWarning{duplicate, truncated}, 2, true), ShouldBeFalse)
+			So(loadVerified(100, 30, []loadWarning{duplicate}, 70, true), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,331 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+)
+
+// config holds the publisher settings read from the task manifest
+type config struct {
+	username string
+	password string
+	hostname string
+	port     string
+	socket   string
+	// dsn overrides the other connection settings when it's set
+	dsn string
+
+	tlsMode string
+	tlsCA   string
+	tlsCert string
+	tlsKey  string
+
+	database   string
+	tableName  string
+	routes     []route
+	autoCreate string
+	migrations string
+	schema     string
+	tags       string
+	metadata   bool
+
+	partitioning    string
+	partitionsAhead int
+	retention       time.Duration
+	rollups         []time.Duration
+
+	uniqueKey   bool
+	onDuplicate string
+
+	batchSize     int
+	concurrency   int
+	bulkThreshold int
+
+	onConversionError string
+	atomic            bool
+
+	retries         int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+
+	spoolPath     string
+	spoolMaxSize  int64
+	spoolEviction string
+
+	async         bool
+	queueSize     int
+	flushSize     int
+	flushInterval time.Duration
+	queueFull     string
+}
+
+// parseConfig reads the processed task config into a config
+func parseConfig(cfg map[string]ctypes.ConfigValue) (*config, error) {
+	c := &config{
+		username:          cfg["username"].(ctypes.ConfigValueStr).Value,
+		password:          cfg["password"].(ctypes.ConfigValueStr).Value,
+		hostname:          cfg["hostname"].(ctypes.ConfigValueStr).Value,
+		port:              cfg["port"].(ctypes.ConfigValueStr).Value,
+		socket:            cfg["socket"].(ctypes.ConfigValueStr).Value,
+		dsn:               cfg["dsn"].(ctypes.ConfigValueStr).Value,
+		tlsMode:           cfg["tls_mode"].(ctypes.ConfigValueStr).Value,
+		tlsCA:             cfg["tls_ca"].(ctypes.ConfigValueStr).Value,
+		tlsCert:           cfg["tls_cert"].(ctypes.ConfigValueStr).Value,
+		tlsKey:            cfg["tls_key"].(ctypes.ConfigValueStr).Value,
+		database:          cfg["database"].(ctypes.ConfigValueStr).Value,
+		tableName:         cfg["tablename"].(ctypes.ConfigValueStr).Value,
+		autoCreate:        cfg["auto_create"].(ctypes.ConfigValueStr).Value,
+		migrations:        cfg["migrations"].(ctypes.ConfigValueStr).Value,
+		schema:            cfg["schema"].(ctypes.ConfigValueStr).Value,
+		tags:              cfg["tags"].(ctypes.ConfigValueStr).Value,
+		metadata:          cfg["metadata"].(ctypes.ConfigValueBool).Value,
+		uniqueKey:         cfg["unique_key"].(ctypes.ConfigValueBool).Value,
+		onDuplicate:       cfg["on_duplicate"].(ctypes.ConfigValueStr).Value,
+		partitioning:      cfg["partitioning"].(ctypes.ConfigValueStr).Value,
+		partitionsAhead:   cfg["partitions_ahead"].(ctypes.ConfigValueInt).Value,
+		retention:         time.Duration(cfg["retention"].(ctypes.ConfigValueInt).Value) * 24 * time.Hour,
+		onConversionError: cfg["on_conversion_error"].(ctypes.ConfigValueStr).Value,
+		atomic:            cfg["atomic"].(ctypes.ConfigValueBool).Value,
+		retries:           cfg["retries"].(ctypes.ConfigValueInt).Value,
+		retryBackoff:      time.Duration(cfg["retry_backoff"].(ctypes.ConfigValueInt).Value) * time.Millisecond,
+		retryMaxBackoff:   time.Duration(cfg["retry_max_backoff"].(ctypes.ConfigValueInt).Value) * time.Millisecond,
+		batchSize:         cfg["batch_size"].(ctypes.ConfigValueInt).Value,
+		concurrency:       cfg["concurrency"].(ctypes.ConfigValueInt).Value,
+		bulkThreshold:     cfg["bulk_threshold"].(ctypes.ConfigValueInt).Value,
+		maxOpenConns:      cfg["max_open_conns"].(ctypes.ConfigValueInt).Value,
+		maxIdleConns:      cfg["max_idle_conns"].(ctypes.ConfigValueInt).Value,
+		connMaxLifetime:   time.Duration(cfg["conn_max_lifetime"].(ctypes.ConfigValueInt).Value) * time.Second,
+		spoolPath:         cfg["spool_path"].(ctypes.ConfigValueStr).Value,
+		spoolMaxSize:      int64(cfg["spool_max_size"].(ctypes.ConfigValueInt).Value) << 20,
+		spoolEviction:     cfg["spool_eviction"].(ctypes.ConfigValueStr).Value,
+		async:             cfg["async"].(ctypes.ConfigValueBool).Value,
+		queueSize:         cfg["queue_size"].(ctypes.ConfigValueInt).Value,
+		flushSize:         cfg["flush_size"].(ctypes.ConfigValueInt).Value,
+		flushInterval:     time.Duration(cfg["flush_interval"].(ctypes.ConfigValueInt).Value) * time.Millisecond,
+		queueFull:         cfg["queue_full"].(ctypes.ConfigValueStr).Value,
+	}
+
+	var err error
+	if c.routes, err = parseRoutes(cfg["routes"].(ctypes.ConfigValueStr).Value); err != nil {
+		return nil, err
+	}
+	if c.rollups, err = parseRollups(cfg["rollups"].(ctypes.ConfigValueStr).Value); err != nil {
+		return nil, err
+	}
+	if c.dsn != "" {
+		if err := applyDSN(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := readPassword(c, cfg["password_file"].(ctypes.ConfigValueStr).Value, cfg["password_env"].(ctypes.ConfigValueStr).Value); err != nil {
+		return nil, err
+	}
+	if err := checkIdentifier("database", c.database); err != nil {
+		return nil, err
+	}
+	for _, table := range c.tableNames() {
+		if err := checkIdentifier("tablename", table); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkAutoCreate(c.autoCreate); err != nil {
+		return nil, err
+	}
+	if err := checkMigrations(c.migrations); err != nil {
+		return nil, err
+	}
+	if err := checkTLS(c); err != nil {
+		return nil, err
+	}
+	l, err := newLayout(c.schema, c.tags)
+	if err != nil {
+		return nil, err
+	}
+	if c.schema == schemaWide && c.tags != tagsNone {
+		return nil, fmt.Errorf("Invalid tags '%s', the %s schema stores only the source of metrics, tags must be %s", c.tags, schemaWide, tagsNone)
+	}
+	if err := checkOnDuplicate(c.onDuplicate); err != nil {
+		return nil, err
+	}
+	if c.uniqueKey && c.schema != schemaTyped {
+		return nil, fmt.Errorf("Invalid unique_key, it's supported by the %s schema, schema is %s", schemaTyped, c.schema)
+	}
+	if err := checkPartitioning(c); err != nil {
+		return nil, err
+	}
+	if c.retention < 0 {
+		return nil, fmt.Errorf("Invalid retention %v, it must not be negative", c.retention)
+	}
+	if c.retention > 0 && c.schema == schemaLegacy {
+		return nil, fmt.Errorf("Invalid retention %v, the %s schema stores timestamps as text, use %s or %s", c.retention, schemaLegacy, schemaTyped, schemaWide)
+	}
+	if len(c.rollups) > 0 && c.schema != schemaTyped {
+		return nil, fmt.Errorf("Invalid rollups, they aggregate the numeric values of the %s schema, schema is %s", schemaTyped, c.schema)
+	}
+	if c.batchSize < 1 || c.batchSize > l.maxBatchSize() {
+		return nil, fmt.Errorf("Invalid batch_size %d, it must be between 1 and %d", c.batchSize, l.maxBatchSize())
+	}
+	if err := checkConversionMode(c.onConversionError); err != nil {
+		return nil, err
+	}
+	if c.retries < 0 {
+		return nil, fmt.Errorf("Invalid retries %d, it must not be negative", c.retries)
+	}
+	if c.retryBackoff < 0 || c.retryMaxBackoff < c.retryBackoff {
+		return nil, fmt.Errorf("Invalid retry_backoff %v and retry_max_backoff %v, backoff must not be negative nor greater than max backoff", c.retryBackoff, c.retryMaxBackoff)
+	}
+	if c.maxOpenConns < 0 {
+		return nil, fmt.Errorf("Invalid max_open_conns %d, it must not be negative", c.maxOpenConns)
+	}
+	if c.concurrency < 1 || c.maxOpenConns > 0 && c.concurrency > c.maxOpenConns {
+		return nil, fmt.Errorf("Invalid concurrency %d, it must be positive and not greater than max_open_conns %d", c.concurrency, c.maxOpenConns)
+	}
+	if err := checkBulk(c); err != nil {
+		return nil, err
+	}
+	if c.maxIdleConns < 0 {
+		return nil, fmt.Errorf("Invalid max_idle_conns %d, it must not be negative", c.maxIdleConns)
+	}
+	if c.connMaxLifetime < 0 {
+		return nil, fmt.Errorf("Invalid conn_max_lifetime %v, it must not be negative", c.connMaxLifetime)
+	}
+	if c.spoolMaxSize < 1 {
+		return nil, fmt.Errorf("Invalid spool_max_size %d MB, it must be positive", c.spoolMaxSize>>20)
+	}
+	if err := checkEviction(c.spoolEviction); err != nil {
+		return nil, err
+	}
+	if c.queueSize < 1 {
+		return nil, fmt.Errorf("Invalid queue_size %d, it must be positive", c.queueSize)
+	}
+	if c.flushSize < 1 || c.flushSize > c.queueSize {
+		return nil, fmt.Errorf("Invalid flush_size %d, it must be between 1 and queue_size %d", c.flushSize, c.queueSize)
+	}
+	if c.flushInterval <= 0 {
+		return nil, fmt.Errorf("Invalid flush_interval %v, it must be positive", c.flushInterval)
+	}
+	if err := checkQueueFull(c.queueFull); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readPassword replaces the password of c with the one read from file or the env variable, when either is set
+func readPassword(c *config, file, env string) error {
+	switch {
+	case file != "" && env != "":
+		return fmt.Errorf("password_file and password_env cannot be set together")
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("Cannot read password_file: %v", err)
+		}
+		c.password = strings.TrimRight(string(b), "\r\n")
+	case env != "":
+		password, ok := os.LookupEnv(env)
+		if !ok {
+			return fmt.Errorf("Environment variable %s set by password_env is not defined", env)
+		}
+		c.password = password
+	}
+	return nil
+}
+
+// destinationTables returns the tables receiving metrics, the default table first
+func (c *config) destinationTables() []string {
+	tables := []string{c.tableName}
+	added := map[string]bool{c.tableName: true}
+	for _, r := range c.routes {
+		if !added[r.table] {
+			added[r.table] = true
+			tables = append(tables, r.table)
+		}
+	}
+	return tables
+}
+
+// tableNames returns the names of all tables storing metrics of c
+func (c *config) tableNames() []string {
+	var tables []string
+	for _, table := range c.destinationTables() {
+		tables = append(tables, table)
+		if c.tags == tagsTable {
+			tables = append(tables, table+tagsTableSuffix, table+tagSetsTableSuffix)
+		}
+		if c.metadata {
+			tables = append(tables, table+metadataTableSuffix, table+elementsTableSuffix)
+		}
+		for _, interval := range c.rollups {
+			tables = append(tables, table+rollupSuffix(interval))
+		}
+	}
+	if c.onConversionError == conversionDeadLetter {
+		tables = append(tables, c.tableName+deadLetterTableSuffix)
+	}
+	return tables
+}
+
+// address returns the address of the server, the socket or host and port
+func (c *config) address() string {
+	if c.socket != "" {
+		return c.socket
+	}
+	return c.hostname + ":" + c.port
+}
+
+// connectionKey identifies the connection pool and statements a config can share with other tasks, tasks share
+// them only when their passwords are equal too; during a rotation the old and the new password get pools of their own
+type connectionKey struct {
+	config string
+	secret [sha256.Size]byte
+}
+
+// key identifies the settings a config shares with other tasks but for the password, which is left out so that
+// it's not kept in the key
+func (c *config) key() string {
+	k := *c
+	k.password = ""
+	if k.dsn != "" {
+		k.dsn = redactURL(k.dsn)
+	}
+	return fmt.Sprintf("%+v", k)
+}
+
+// secret returns a hash of the password
+func (c *config) secret() [sha256.Size]byte {
+	return sha256.Sum256([]byte(c.password))
+}
+
+// connectionKey returns the key of the connection of c
+func (c *config) connectionKey() connectionKey {
+	return connectionKey{config: c.key(), secret: c.secret()}
+}
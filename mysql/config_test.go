@@ -0,0 +1,293 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func defaultTestConfig() map[string]ctypes.ConfigValue {
+	cp, _ := NewMySQLPublisher().GetConfigPolicy()
+	cfg, _ := cp.Get([]string{""}).Process(map[string]ctypes.ConfigValue{})
+	return *cfg
+}
+
+func TestParseConfig(t *testing.T) {
+	Convey("Parse processed task config", t, func() {
+		cfg := defaultTestConfig()
+
+		Convey("So defaults should be parsed", func() {
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.tableName, ShouldEqual, "info")
+			So(c.batchSize, ShouldEqual, 100)
+			So(c.maxOpenConns, ShouldEqual, 10)
+			So(c.connMaxLifetime, ShouldEqual, time.Duration(0))
+		})
+		Convey("So conn_max_lifetime should be read as seconds", func() {
+			cfg["conn_max_lifetime"] = ctypes.ConfigValueInt{Value: 30}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.connMaxLifetime, ShouldEqual, 30*time.Second)
+		})
+		Convey("So an out of range batch_size should return an error", func() {
+			cfg["batch_size"] = ctypes.ConfigValueInt{Value: 0}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["batch_size"] = ctypes.ConfigValueInt{Value: maxPlaceholders/4 + 1}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So invalid database and table names should return an error", func() {
+			cfg["database"] = ctypes.ConfigValueStr{Value: "snap.test"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+
+			cfg["database"] = ctypes.ConfigValueStr{Value: "snap-test"}
+			cfg["tablename"] = ctypes.ConfigValueStr{Value: ""}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So a table name should leave room for the suffixes of auxiliary tables", func() {
+			cfg["tablename"] = ctypes.ConfigValueStr{Value: strings.Repeat("a", 50)}
+			_, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+
+			cfg["metadata"] = ctypes.ConfigValueBool{Value: true}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So route tables should be destinations after the default table", func() {
+			cfg["routes"] = ctypes.ConfigValueStr{Value: "/intel/procfs=procfs_metrics,/intel/mock=info,/intel/psutil=procfs_metrics"}
+			cfg["tags"] = ctypes.ConfigValueStr{Value: "table"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.destinationTables(), ShouldResemble, []string{"info", "procfs_metrics"})
+			So(c.tableNames(), ShouldResemble, []string{"info", "info_tags", "info_tag_sets", "procfs_metrics", "procfs_metrics_tags", "procfs_metrics_tag_sets"})
+		})
+		Convey("So an invalid route table should return an error", func() {
+			cfg["routes"] = ctypes.ConfigValueStr{Value: "/intel/procfs=procfs.metrics"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So tags with the wide schema should return an error", func() {
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "wide"}
+			cfg["tags"] = ctypes.ConfigValueStr{Value: "json"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So retention should be read as days", func() {
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+			cfg["partitioning"] = ctypes.ConfigValueStr{Value: "month"}
+			cfg["retention"] = ctypes.ConfigValueInt{Value: 90}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.retention, ShouldEqual, 90*24*time.Hour)
+		})
+		Convey("So invalid partitioning should return an error", func() {
+			cfg["partitioning"] = ctypes.ConfigValueStr{Value: "day"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+			cfg["partitions_ahead"] = ctypes.ConfigValueInt{Value: 0}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["partitioning"] = ctypes.ConfigValueStr{Value: "week"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So rollup tables should be kept for every destination table", func() {
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+			cfg["rollups"] = ctypes.ConfigValueStr{Value: "1m,1h"}
+			cfg["routes"] = ctypes.ConfigValueStr{Value: "/intel/procfs=procfs"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.rollups, ShouldResemble, []time.Duration{time.Minute, time.Hour})
+			So(c.tableNames(), ShouldResemble, []string{"info", "info_rollup_1m", "info_rollup_1h", "procfs", "procfs_rollup_1m", "procfs_rollup_1h"})
+		})
+		Convey("So rollups and retention of legacy tables should return an error", func() {
+			cfg["rollups"] = ctypes.ConfigValueStr{Value: "1m"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["rollups"] = ctypes.ConfigValueStr{Value: ""}
+			cfg["retention"] = ctypes.ConfigValueInt{Value: 7}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "wide"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldBeNil)
+			cfg["retention"] = ctypes.ConfigValueInt{Value: -1}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So a unique key of other than typed tables should return an error", func() {
+			cfg["unique_key"] = ctypes.ConfigValueBool{Value: true}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "wide"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldBeNil)
+		})
+		Convey("So an unknown on_duplicate should return an error", func() {
+			cfg["on_duplicate"] = ctypes.ConfigValueStr{Value: "replace"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So flush_interval should be read as milliseconds", func() {
+			cfg["flush_interval"] = ctypes.ConfigValueInt{Value: 250}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.flushInterval, ShouldEqual, 250*time.Millisecond)
+		})
+		Convey("So an invalid queue should return an error", func() {
+			cfg["flush_size"] = ctypes.ConfigValueInt{Value: 20000}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["flush_size"] = ctypes.ConfigValueInt{Value: 1000}
+			cfg["flush_interval"] = ctypes.ConfigValueInt{Value: 0}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["flush_interval"] = ctypes.ConfigValueInt{Value: 1000}
+			cfg["queue_full"] = ctypes.ConfigValueStr{Value: "drop"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So concurrency should be limited by max_open_conns", func() {
+			cfg["concurrency"] = ctypes.ConfigValueInt{Value: 11}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["max_open_conns"] = ctypes.ConfigValueInt{Value: 0}
+			_, err = parseConfig(cfg)
+			So(err, ShouldBeNil)
+			cfg["concurrency"] = ctypes.ConfigValueInt{Value: 0}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So an unknown auto_create should return an error", func() {
+			cfg["auto_create"] = ctypes.ConfigValueStr{Value: "database"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So an unknown schema should return an error", func() {
+			cfg["schema"] = ctypes.ConfigValueStr{Value: "unknown"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So a negative pool setting should return an error", func() {
+			cfg["max_idle_conns"] = ctypes.ConfigValueInt{Value: -1}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So retry delays should be read as milliseconds", func() {
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.retryBackoff, ShouldEqual, 100*time.Millisecond)
+			So(c.retryMaxBackoff, ShouldEqual, 5*time.Second)
+		})
+		Convey("So a backoff greater than the max backoff should return an error", func() {
+			cfg["retry_backoff"] = ctypes.ConfigValueInt{Value: 10000}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So spool_max_size should be read as megabytes", func() {
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.spoolMaxSize, ShouldEqual, 100<<20)
+		})
+		Convey("So the password should be read from password_file", func() {
+			f, err := ioutil.TempFile("", "snap-mysql-password")
+			So(err, ShouldBeNil)
+			defer os.Remove(f.Name())
+			f.WriteString("s3cret\n")
+			f.Close()
+
+			cfg["password_file"] = ctypes.ConfigValueStr{Value: f.Name()}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.password, ShouldEqual, "s3cret")
+		})
+		Convey("So the password should be read from password_env", func() {
+			os.Setenv("SNAP_MYSQL_TEST_PASSWORD", "s3cret")
+			defer os.Unsetenv("SNAP_MYSQL_TEST_PASSWORD")
+
+			cfg["password_env"] = ctypes.ConfigValueStr{Value: "SNAP_MYSQL_TEST_PASSWORD"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.password, ShouldEqual, "s3cret")
+		})
+		Convey("So a missing password source should return an error", func() {
+			cfg["password_file"] = ctypes.ConfigValueStr{Value: "/nonexistent/snap-mysql-password"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+
+			cfg["password_file"] = ctypes.ConfigValueStr{Value: ""}
+			cfg["password_env"] = ctypes.ConfigValueStr{Value: "SNAP_MYSQL_UNDEFINED_PASSWORD"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So an unknown tls_mode should return an error", func() {
+			cfg["tls_mode"] = ctypes.ConfigValueStr{Value: "verify-full"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So an unknown spool_eviction should return an error", func() {
+			cfg["spool_eviction"] = ctypes.ConfigValueStr{Value: "unknown"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So equal configs should share a key", func() {
+			c1, _ := parseConfig(cfg)
+			c2, _ := parseConfig(defaultTestConfig())
+			So(c1.key(), ShouldEqual, c2.key())
+		})
+		Convey("So configs with different tables should not share a key", func() {
+			c1, _ := parseConfig(cfg)
+			cfg["tablename"] = ctypes.ConfigValueStr{Value: "info1"}
+			c2, _ := parseConfig(cfg)
+			So(c1.key(), ShouldNotEqual, c2.key())
+		})
+		Convey("So configs with different passwords should share a key without the password but not a connection", func() {
+			cfg["password"] = ctypes.ConfigValueStr{Value: "s3cret"}
+			c1, _ := parseConfig(cfg)
+			cfg["password"] = ctypes.ConfigValueStr{Value: "rotated"}
+			c2, _ := parseConfig(cfg)
+			So(c1.key(), ShouldEqual, c2.key())
+			So(c1.key(), ShouldNotContainSubstring, "s3cret")
+			So(c1.secret(), ShouldNotEqual, c2.secret())
+			So(c1.connectionKey(), ShouldNotResemble, c2.connectionKey())
+
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "root:s3cret@tcp(localhost:3306)/snap"}
+			c3, _ := parseConfig(cfg)
+			So(c3.key(), ShouldNotContainSubstring, "s3cret")
+		})
+	})
+}
@@ -0,0 +1,137 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+)
+
+const (
+	// conversionFail fails the whole publish when a metric cannot be converted
+	conversionFail = "fail"
+	// conversionSkip skips metrics which cannot be converted and logs them
+	conversionSkip = "skip_and_log"
+	// conversionDeadLetter stores metrics which cannot be converted in the dead letter table
+	conversionDeadLetter = "dead_letter"
+
+	// deadLetterTableSuffix is appended to the metrics table name to get the name of the dead letter table
+	deadLetterTableSuffix   = "_dead_letter"
+	deadLetterColumnsPerRow = 5
+)
+
+// metricFailure is a metric whose value cannot be converted for insertion
+type metricFailure struct {
+	metric plugin.MetricType
+	err    error
+}
+
+// conversionError reports all metrics of a payload whose values cannot be converted
+type conversionError struct {
+	failures []metricFailure
+}
+
+func (e *conversionError) Error() string {
+	msgs := make([]string, len(e.failures))
+	for i, f := range e.failures {
+		msgs[i] = fmt.Sprintf("%s: %v", f.metric.Namespace().String(), f.err)
+	}
+	return fmt.Sprintf("Cannot convert %d metric(s): %s", len(e.failures), strings.Join(msgs, "; "))
+}
+
+func checkConversionMode(mode string) error {
+	switch mode {
+	case conversionFail, conversionSkip, conversionDeadLetter:
+		return nil
+	}
+	return fmt.Errorf("Unknown on_conversion_error '%s', supported values: %s, %s, %s", mode, conversionFail, conversionSkip, conversionDeadLetter)
+}
+
+// deadLetterTableColumns are the columns of the dead letter table written by the publisher
+var deadLetterTableColumns = []column{{name: "timestamp", definition: "DATETIME"}, {name: "source_column", definition: "VARCHAR"},
+	{name: "key_column", definition: "VARCHAR"}, {name: "raw_value", definition: "BLOB"}, {name: "error", definition: "TEXT"}}
+
+// deadLetterTableQuery returns the statement creating the dead letter table if it's not already there
+func deadLetterTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, timestamp DATETIME(6) NOT NULL, " +
+		"source_column VARCHAR(255) NOT NULL, key_column VARCHAR(255) NOT NULL, raw_value BLOB NULL, error TEXT NOT NULL, " +
+		"created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (id))"
+}
+
+// deadLetterInsertQuery returns a multi-row INSERT statement for the given number of metrics
+func deadLetterInsertQuery(table string, rows int) string {
+	return "INSERT INTO" + " " + table + " (timestamp, source_column, key_column, raw_value, error) VALUES " + placeholders(rows, deadLetterColumnsPerRow)
+}
+
+// handleConversionFailures applies the on_conversion_error policy to metrics which cannot be converted
+func (conn *connection) handleConversionFailures(ex execer, failures []metricFailure, logger *log.Logger) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	namespaces := make([]string, len(failures))
+	for i, f := range failures {
+		namespaces[i] = f.metric.Namespace().String()
+	}
+
+	switch conn.onConversionError {
+	case conversionSkip:
+		logger.WithFields(log.Fields{
+			"skipped":    len(failures),
+			"namespaces": namespaces,
+		}).Warnf("Skipped metrics which cannot be converted: %v", &conversionError{failures})
+	case conversionDeadLetter:
+		if err := conn.insertDeadLetters(ex, failures); err != nil {
+			return err
+		}
+		logger.WithFields(log.Fields{
+			"dead_lettered": len(failures),
+			"namespaces":    namespaces,
+			"table":         conn.deadLetterTableName,
+		}).Warnf("Stored metrics which cannot be converted in the dead letter table: %v", &conversionError{failures})
+	default:
+		return &conversionError{failures}
+	}
+	return nil
+}
+
+// insertDeadLetters stores metrics, gob encoded, together with the conversion error
+func (conn *connection) insertDeadLetters(ex execer, failures []metricFailure) error {
+	args := make([]interface{}, 0, len(failures)*deadLetterColumnsPerRow)
+	for _, f := range failures {
+		var raw []byte
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(f.metric); err == nil {
+			raw = buf.Bytes()
+		}
+		args = append(args, f.metric.Timestamp(), f.metric.Tags()[core.STD_TAG_PLUGIN_RUNNING_ON],
+			sliceToString(f.metric.Namespace().Strings()), raw, f.err.Error())
+	}
+	return execBatches(ex, args, deadLetterColumnsPerRow, func(n int) string {
+		return deadLetterInsertQuery(conn.deadLetterTableName, n)
+	})
+}
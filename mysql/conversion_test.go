@@ -0,0 +1,68 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConversionFailures(t *testing.T) {
+	failures := []metricFailure{
+		{*plugin.NewMetricType(core.NewNamespace("test", "a"), time.Now(), nil, "", complex(1, 1)), errors.New("Unsupported type complex128")},
+		{*plugin.NewMetricType(core.NewNamespace("test", "b"), time.Now(), nil, "", complex(1, 1)), errors.New("Unsupported type complex128")},
+	}
+
+	Convey("Handle metrics which cannot be converted", t, func() {
+		Convey("So the error should list every metric", func() {
+			err := &conversionError{failures}
+			So(err.Error(), ShouldEqual, "Cannot convert 2 metric(s): /test/a: Unsupported type complex128; /test/b: Unsupported type complex128")
+		})
+		Convey("So fail mode should return a conversion error", func() {
+			conn := &connection{onConversionError: conversionFail}
+			err := conn.handleConversionFailures(nil, failures, log.New())
+			So(err, ShouldHaveSameTypeAs, &conversionError{})
+		})
+		Convey("So skip_and_log mode should not return an error", func() {
+			conn := &connection{onConversionError: conversionSkip}
+			So(conn.handleConversionFailures(nil, failures, log.New()), ShouldBeNil)
+		})
+		Convey("So no failures should not return an error", func() {
+			conn := &connection{onConversionError: conversionFail}
+			So(conn.handleConversionFailures(nil, nil, log.New()), ShouldBeNil)
+		})
+		Convey("So unknown modes should be rejected", func() {
+			So(checkConversionMode(conversionDeadLetter), ShouldBeNil)
+			So(checkConversionMode("ignore"), ShouldNotBeNil)
+		})
+		Convey("So dead letters should be inserted with one group of placeholders per metric", func() {
+			So(deadLetterInsertQuery("info_dead_letter", 2), ShouldEqual,
+				"INSERT INTO info_dead_letter (timestamp, source_column, key_column, raw_value, error) VALUES ( ?, ?, ?, ?, ? ), ( ?, ?, ?, ?, ? )")
+		})
+	})
+}
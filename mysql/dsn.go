@@ -0,0 +1,102 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// applyDSN replaces the connection settings of c with the ones of its dsn, the database of the dsn replaces the database option
+func applyDSN(c *config) error {
+	dsn, err := mysqldriver.ParseDSN(c.dsn)
+	if err != nil {
+		return fmt.Errorf("Invalid dsn: %v", err)
+	}
+
+	c.username = dsn.User
+	c.password = dsn.Passwd
+	c.hostname, c.port, c.socket = "", "", ""
+	switch dsn.Net {
+	case "unix":
+		c.socket = dsn.Addr
+	default:
+		if c.hostname, c.port, err = net.SplitHostPort(dsn.Addr); err != nil {
+			return fmt.Errorf("Invalid dsn: %v", err)
+		}
+	}
+	if dsn.DBName != "" {
+		c.database = dsn.DBName
+	}
+	return nil
+}
+
+// getMySQLConnectionURL returns the DSN connecting to the server described by c without selecting a database,
+// so that the database can be created when it's missing
+func getMySQLConnectionURL(c *config, tlsConfigName string) string {
+	var dsn *mysqldriver.Config
+	if c.dsn != "" {
+		// the dsn is validated by parseConfig
+		dsn, _ = mysqldriver.ParseDSN(c.dsn)
+		dsn.DBName = ""
+		// the password may be read from password_file or password_env
+		dsn.Passwd = c.password
+		// DATETIME values are read as text and parsed in loc, with parseTime the driver would return them as time.Time
+		dsn.ParseTime = false
+	} else {
+		// NewConfig sets the defaults of the driver, e.g. native password authentication
+		dsn = mysqldriver.NewConfig()
+		dsn.User, dsn.Passwd = c.username, c.password
+		if c.socket != "" {
+			dsn.Net, dsn.Addr = "unix", c.socket
+		} else {
+			dsn.Net, dsn.Addr = "tcp", net.JoinHostPort(c.hostname, c.port)
+		}
+	}
+
+	// tls_mode takes precedence over the tls parameter of the dsn
+	if tlsConfigName != "" {
+		dsn.TLSConfig = tlsConfigName
+	}
+	return dsn.FormatDSN()
+}
+
+// urlLocation returns the time zone the driver converts timestamps to for connections to url, set by its loc parameter
+func urlLocation(url string) *time.Location {
+	if dsn, err := mysqldriver.ParseDSN(url); err == nil && dsn.Loc != nil {
+		return dsn.Loc
+	}
+	return time.UTC
+}
+
+// redactURL returns url with the password masked, so that it can be logged
+func redactURL(url string) string {
+	dsn, err := mysqldriver.ParseDSN(url)
+	if err != nil {
+		return "(invalid dsn)"
+	}
+	if dsn.Passwd != "" {
+		dsn.Passwd = "xxxxx"
+	}
+	return dsn.FormatDSN()
+}
@@ -0,0 +1,112 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"os"
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDSN(t *testing.T) {
+	Convey("Connect using socket or dsn", t, func() {
+		cfg := defaultTestConfig()
+
+		Convey("So a tcp connection URL should be built from hostname and port", func() {
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(getMySQLConnectionURL(c, ""), ShouldEqual, "root@tcp(localhost:3306)/")
+		})
+		Convey("So a socket should be used instead of hostname and port", func() {
+			cfg["socket"] = ctypes.ConfigValueStr{Value: "/var/run/mysqld/mysqld.sock"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.address(), ShouldEqual, "/var/run/mysqld/mysqld.sock")
+			So(getMySQLConnectionURL(c, ""), ShouldEqual, "root@unix(/var/run/mysqld/mysqld.sock)/")
+		})
+		Convey("So a dsn should override the other connection settings and keep its parameters", func() {
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap:secret@tcp(db.example.com:3307)/metrics?timeout=5s&readTimeout=10s&charset=utf8mb4&maxAllowedPacket=8388608"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.username, ShouldEqual, "snap")
+			So(c.password, ShouldEqual, "secret")
+			So(c.address(), ShouldEqual, "db.example.com:3307")
+			So(c.database, ShouldEqual, "metrics")
+
+			url := getMySQLConnectionURL(c, "")
+			So(url, ShouldStartWith, "snap:secret@tcp(db.example.com:3307)/?")
+			So(url, ShouldContainSubstring, "timeout=5s")
+			So(url, ShouldContainSubstring, "readTimeout=10s")
+			So(url, ShouldContainSubstring, "charset=utf8mb4")
+			So(url, ShouldContainSubstring, "maxAllowedPacket=8388608")
+		})
+		Convey("So parseTime should be left out, timestamps are read as text", func() {
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap:secret@tcp(db.example.com:3307)/metrics?parseTime=true&loc=Europe%2FBerlin"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			url := getMySQLConnectionURL(c, "")
+			So(url, ShouldNotContainSubstring, "parseTime")
+			dsn, err := mysqldriver.ParseDSN(url)
+			So(err, ShouldBeNil)
+			So(dsn.ParseTime, ShouldBeFalse)
+			So(urlLocation(url).String(), ShouldEqual, "Europe/Berlin")
+		})
+		Convey("So a dsn without a database should keep the database option", func() {
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap@unix(/tmp/mysql.sock)/"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(c.socket, ShouldEqual, "/tmp/mysql.sock")
+			So(c.database, ShouldEqual, "SNAP_TEST")
+		})
+		Convey("So TLS settings should be added to a dsn", func() {
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap:secret@tcp(db.example.com:3307)/"}
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(getMySQLConnectionURL(c, "custom"), ShouldEqual, "snap:secret@tcp(db.example.com:3307)/?tls=custom")
+		})
+		Convey("So a password read from password_env should replace the password of a dsn", func() {
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap:secret@tcp(db.example.com:3307)/"}
+			cfg["password_env"] = ctypes.ConfigValueStr{Value: "SNAP_MYSQL_TEST_PASSWORD"}
+			os.Setenv("SNAP_MYSQL_TEST_PASSWORD", "rotated")
+			defer os.Unsetenv("SNAP_MYSQL_TEST_PASSWORD")
+			c, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(getMySQLConnectionURL(c, ""), ShouldEqual, "snap:rotated@tcp(db.example.com:3307)/")
+		})
+		Convey("So the password should be masked in a logged URL", func() {
+			So(redactURL("snap:secret@tcp(db.example.com:3307)/?timeout=5s"), ShouldEqual, "snap:xxxxx@tcp(db.example.com:3307)/?timeout=5s")
+			So(redactURL("snap@tcp(db.example.com:3307)/"), ShouldEqual, "snap@tcp(db.example.com:3307)/")
+			So(redactURL("snap:secret@tcp(db.example.com:3307)"), ShouldNotContainSubstring, "secret")
+		})
+		Convey("So an invalid dsn should return an error", func() {
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap:secret@tcp(db.example.com:3307)"}
+			_, err := parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+			cfg["dsn"] = ctypes.ConfigValueStr{Value: "snap:secret@tcp(db.example.com:3307)/?timeout=soon"}
+			_, err = parseConfig(cfg)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,61 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxIdentifierLength is the maximum number of characters of MySQL database and table names
+const maxIdentifierLength = 64
+
+// checkIdentifier validates a database or table name, set by option, against MySQL rules for quoted identifiers
+func checkIdentifier(option, name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("Invalid %s, it must not be empty", option)
+	case !utf8.ValidString(name):
+		return fmt.Errorf("Invalid %s '%s', it must be valid UTF-8", option, name)
+	case utf8.RuneCountInString(name) > maxIdentifierLength:
+		return fmt.Errorf("Invalid %s '%s', it must not be longer than %d characters", option, name, maxIdentifierLength)
+	case strings.HasSuffix(name, " "):
+		return fmt.Errorf("Invalid %s '%s', it must not end with a space", option, name)
+	case strings.ContainsAny(name, "/\\."):
+		return fmt.Errorf("Invalid %s '%s', it must not contain '/', '\\' or '.'", option, name)
+	}
+	for _, r := range name {
+		if r == 0 || r > 0xFFFF {
+			return fmt.Errorf("Invalid %s '%s', it must not contain NUL nor characters outside the Basic Multilingual Plane", option, name)
+		}
+	}
+	return nil
+}
+
+// quoteIdentifier returns name quoted with backticks, so that it can be used in a statement
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// qualifiedName returns the quoted name of table qualified with database
+func qualifiedName(database, table string) string {
+	return quoteIdentifier(database) + "." + quoteIdentifier(table)
+}
@@ -0,0 +1,50 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdentifier(t *testing.T) {
+	Convey("Validate and quote database and table names", t, func() {
+		Convey("So names with dashes, spaces, reserved words and backticks should be valid", func() {
+			for _, name := range []string{"info", "snap-metrics", "my metrics", "select", "in`fo", "métriques", strings.Repeat("a", 64)} {
+				So(checkIdentifier("tablename", name), ShouldBeNil)
+			}
+		})
+		Convey("So names breaking MySQL rules should return an error", func() {
+			for _, name := range []string{"", "info ", "db.info", "info/x", "info\\x", "in\x00fo", "info\U0001F600", "\xff", strings.Repeat("a", 65)} {
+				So(checkIdentifier("tablename", name), ShouldNotBeNil)
+			}
+		})
+		Convey("So quoted names should escape backticks", func() {
+			So(quoteIdentifier("info"), ShouldEqual, "`info`")
+			So(quoteIdentifier("in`fo"), ShouldEqual, "`in``fo`")
+			So(quoteIdentifier("x`; DROP TABLE info; --"), ShouldEqual, "`x``; DROP TABLE info; --`")
+			So(qualifiedName("snap-test", "info"), ShouldEqual, "`snap-test`.`info`")
+		})
+	})
+}
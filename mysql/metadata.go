@@ -0,0 +1,136 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+const (
+	// metadataTableSuffix is appended to the metrics table name to get the name of the metadata table
+	metadataTableSuffix = "_metadata"
+	// elementsTableSuffix is appended to the metrics table name to get the name of the namespace elements table
+	elementsTableSuffix = "_namespace_elements"
+
+	metadataColumnsPerRow = 3
+	elementsColumnsPerRow = 5
+)
+
+// metadata holds the unit and description of a metric together with the dynamic elements of its namespace
+type metadata struct {
+	namespace   string
+	unit        string
+	description string
+	elements    []element
+}
+
+// element is a dynamic namespace element, like the id of a CPU
+type element struct {
+	position    int
+	name        string
+	value       string
+	description string
+}
+
+// rowMetadata returns the metadata of the metric stored in a row
+func rowMetadata(r *row) metadata {
+	md := metadata{namespace: r.key, unit: r.unit, description: r.description}
+	for i, e := range r.namespace {
+		if e.IsDynamic() {
+			md.elements = append(md.elements, element{position: i, name: e.Name, value: e.Value, description: e.Description})
+		}
+	}
+	return md
+}
+
+// equal reports whether metadata holds the same values as md
+func (md metadata) equal(other metadata) bool {
+	if md.namespace != other.namespace || md.unit != other.unit || md.description != other.description || len(md.elements) != len(other.elements) {
+		return false
+	}
+	for i := range md.elements {
+		if md.elements[i] != other.elements[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataTableColumns and elementsTableColumns are the columns of the metadata tables written by the publisher
+var (
+	metadataTableColumns = []column{{name: "namespace", definition: "VARCHAR"}, {name: "unit", definition: "VARCHAR"}, {name: "description", definition: "TEXT"}}
+	elementsTableColumns = []column{{name: "namespace", definition: "VARCHAR"}, {name: "position", definition: "INT"},
+		{name: "name", definition: "VARCHAR"}, {name: "value", definition: "VARCHAR"}, {name: "description", definition: "TEXT"}}
+)
+
+// metadataTableQuery returns the statement creating the metadata table if it's not already there
+func metadataTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (namespace VARCHAR(255) NOT NULL, unit VARCHAR(255) NOT NULL, description TEXT NOT NULL, " +
+		"updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (namespace))"
+}
+
+// elementsTableQuery returns the statement creating the namespace elements table if it's not already there
+func elementsTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (namespace VARCHAR(255) NOT NULL, position INT NOT NULL, name VARCHAR(255) NOT NULL, " +
+		"value VARCHAR(255) NOT NULL, description TEXT NOT NULL, PRIMARY KEY (namespace, position), INDEX name_value (name, value))"
+}
+
+// metadataUpsertQuery returns a multi-row statement inserting or updating the metadata of the given number of namespaces
+func metadataUpsertQuery(table string, rows int) string {
+	return "INSERT INTO" + " " + table + " (namespace, unit, description) VALUES " + placeholders(rows, metadataColumnsPerRow) +
+		" ON DUPLICATE KEY UPDATE unit = VALUES(unit), description = VALUES(description)"
+}
+
+// elementsUpsertQuery returns a multi-row statement inserting or updating the given number of namespace elements
+func elementsUpsertQuery(table string, rows int) string {
+	return "INSERT INTO" + " " + table + " (namespace, position, name, value, description) VALUES " + placeholders(rows, elementsColumnsPerRow) +
+		" ON DUPLICATE KEY UPDATE name = VALUES(name), value = VALUES(value), description = VALUES(description)"
+}
+
+// upsertMetadata stores the metadata of rows which is new or changed since it was stored in the metadata tables of d,
+// it returns the stored metadata by namespace
+func (d *destination) upsertMetadata(ex execer, rows []row) (map[string]metadata, error) {
+	changed := map[string]metadata{}
+	var metadataArgs, elementsArgs []interface{}
+	d.mu.Lock()
+	for i := range rows {
+		md := rowMetadata(&rows[i])
+		if stored, ok := d.storedMetadata[md.namespace]; ok && stored.equal(md) {
+			continue
+		}
+		if _, ok := changed[md.namespace]; ok {
+			continue
+		}
+		changed[md.namespace] = md
+		metadataArgs = append(metadataArgs, md.namespace, md.unit, md.description)
+		for _, e := range md.elements {
+			elementsArgs = append(elementsArgs, md.namespace, e.position, e.name, e.value, e.description)
+		}
+	}
+	d.mu.Unlock()
+
+	if err := execBatches(ex, metadataArgs, metadataColumnsPerRow, func(n int) string {
+		return metadataUpsertQuery(d.metadataTableName, n)
+	}); err != nil {
+		return nil, err
+	}
+	if err := execBatches(ex, elementsArgs, elementsColumnsPerRow, func(n int) string {
+		return elementsUpsertQuery(d.elementsTableName, n)
+	}); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
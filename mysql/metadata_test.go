@@ -0,0 +1,65 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetadata(t *testing.T) {
+	ns := core.NewNamespace("intel", "procfs", "cpu").
+		AddDynamicElement("cpu_id", "id of CPU").
+		AddStaticElement("user")
+	ns[3].Value = "0"
+	r := row{key: sliceToString(ns.Strings()), namespace: ns, unit: "ns", description: "time spent in user mode"}
+
+	Convey("Get metadata of a metric", t, func() {
+		md := rowMetadata(&r)
+		Convey("So unit and description should be kept", func() {
+			So(md.namespace, ShouldEqual, "intel, procfs, cpu, 0, user")
+			So(md.unit, ShouldEqual, "ns")
+			So(md.description, ShouldEqual, "time spent in user mode")
+		})
+		Convey("So only dynamic elements should be kept as name/value pairs", func() {
+			So(md.elements, ShouldResemble, []element{{position: 3, name: "cpu_id", value: "0", description: "id of CPU"}})
+		})
+		Convey("So metadata should equal the metadata of the same metric", func() {
+			So(md.equal(rowMetadata(&r)), ShouldBeTrue)
+		})
+		Convey("So metadata should not equal metadata with another unit", func() {
+			other := r
+			other.unit = "ms"
+			So(md.equal(rowMetadata(&other)), ShouldBeFalse)
+		})
+	})
+
+	Convey("Build metadata statements", t, func() {
+		So(placeholders(2, 3), ShouldEqual, "( ?, ?, ? ), ( ?, ?, ? )")
+		So(metadataUpsertQuery("info_metadata", 1), ShouldEqual,
+			"INSERT INTO info_metadata (namespace, unit, description) VALUES ( ?, ?, ? ) ON DUPLICATE KEY UPDATE unit = VALUES(unit), description = VALUES(description)")
+		So(elementsUpsertQuery("info_namespace_elements", 1), ShouldStartWith,
+			"INSERT INTO info_namespace_elements (namespace, position, name, value, description) VALUES ( ?, ?, ?, ?, ? ) ON DUPLICATE KEY UPDATE")
+	})
+}
@@ -0,0 +1,293 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// migrationsApply migrates tables to the layout of their schema when the publisher starts
+	migrationsApply = "apply"
+	// migrationsDryRun only logs the statements which would migrate tables
+	migrationsDryRun = "dry_run"
+
+	// schemaVersionTable records the migrations applied to every metrics table of a database
+	schemaVersionTable = "snap_schema_version"
+
+	// versionLegacy is the version of tables with the legacy layout
+	versionLegacy = 1
+
+	// errNoSuchTable is the MySQL server error number returned for a missing table
+	errNoSuchTable = 1146
+)
+
+// tableState describes the columns and indexes of an existing table
+type tableState struct {
+	// columns maps lower case names of columns to their data types
+	columns map[string]string
+	indexes map[string]bool
+}
+
+// migrationStep is a statement changing the layout of a table
+type migrationStep struct {
+	// done reports whether the step is already applied, so that a migration interrupted by an error is resumed,
+	// steps without it are safe to repeat
+	done  func(s *tableState) bool
+	query func(table string) string
+}
+
+// migration moves a table from the previous version to version
+type migration struct {
+	version     int
+	description string
+	steps       []migrationStep
+}
+
+// schemaMigrations move tables from the legacy layout to the typed one, in order
+var schemaMigrations = []migration{
+	{
+		version:     2,
+		description: "convert timestamps to DATETIME and store numbers in value_numeric",
+		steps: []migrationStep{
+			{
+				done: func(s *tableState) bool { return s.columns["value_numeric"] != "" },
+				query: func(table string) string {
+					return "ALTER TABLE " + table + " ADD COLUMN value_numeric DOUBLE NULL AFTER key_column"
+				},
+			},
+			{
+				done: func(s *tableState) bool { return typeFamily(s.columns["timestamp"]) == "datetime" },
+				query: func(table string) string {
+					return "UPDATE " + table + " SET value_numeric = value_column + 0, value_column = NULL " +
+						"WHERE value_numeric IS NULL AND value_column REGEXP '^[-+]?[0-9]*\\\\.?[0-9]+([eE][-+]?[0-9]+)?$'"
+				},
+			},
+			{
+				done: func(s *tableState) bool { return typeFamily(s.columns["timestamp"]) == "datetime" },
+				query: func(table string) string {
+					return "ALTER TABLE " + table + " MODIFY timestamp DATETIME(6) NOT NULL, MODIFY source_column VARCHAR(255) NOT NULL, " +
+						"MODIFY key_column VARCHAR(255) NOT NULL, MODIFY value_column TEXT NULL"
+				},
+			},
+			{
+				done: func(s *tableState) bool { return s.columns["id"] != "" },
+				query: func(table string) string {
+					return "ALTER TABLE " + table + " ADD COLUMN id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST"
+				},
+			},
+		},
+	},
+	{
+		version:     3,
+		description: "add indexes for time-range queries",
+		steps: []migrationStep{
+			{
+				done: func(s *tableState) bool { return s.indexes["namespace_timestamp"] },
+				query: func(table string) string {
+					return "ALTER TABLE " + table + " ADD INDEX namespace_timestamp (key_column, timestamp)"
+				},
+			},
+			{
+				done: func(s *tableState) bool { return s.indexes["source_timestamp"] },
+				query: func(table string) string {
+					return "ALTER TABLE " + table + " ADD INDEX source_timestamp (source_column, timestamp)"
+				},
+			},
+		},
+	},
+	{
+		version:     4,
+		description: "add timestamp index for rollups and purging",
+		steps: []migrationStep{
+			{
+				done: func(s *tableState) bool { return s.indexes["timestamp"] },
+				query: func(table string) string {
+					return "ALTER TABLE " + table + " ADD INDEX timestamp (timestamp)"
+				},
+			},
+		},
+	},
+}
+
+func checkMigrations(mode string) error {
+	switch mode {
+	case migrationsApply, migrationsDryRun:
+		return nil
+	}
+	return fmt.Errorf("Unknown migrations '%s', supported values: %s, %s", mode, migrationsApply, migrationsDryRun)
+}
+
+// schemaVersion returns the version of the layout of schema
+func schemaVersion(schema string) int {
+	if schema == schemaTyped {
+		return schemaMigrations[len(schemaMigrations)-1].version
+	}
+	return versionLegacy
+}
+
+// detectVersion returns the version of a table which was not recorded in the schema version table, based on its layout
+func detectVersion(s *tableState) int {
+	switch {
+	case typeFamily(s.columns["timestamp"]) != "datetime":
+		return versionLegacy
+	case !s.indexes["namespace_timestamp"]:
+		return 2
+	case !s.indexes["timestamp"]:
+		return 3
+	}
+	return 4
+}
+
+// pendingSteps returns the statements migrating a table from version to target
+func pendingSteps(s *tableState, table string, version, target int) map[int][]string {
+	pending := map[int][]string{}
+	for _, m := range schemaMigrations {
+		if m.version <= version || m.version > target {
+			continue
+		}
+		for _, step := range m.steps {
+			if step.done == nil || !step.done(s) {
+				pending[m.version] = append(pending[m.version], step.query(table))
+			}
+		}
+	}
+	return pending
+}
+
+// schemaVersionTableQuery returns the statement creating the schema version table if it's not already there
+func schemaVersionTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (table_name VARCHAR(64) NOT NULL, version INT NOT NULL, description VARCHAR(255) NOT NULL, " +
+		"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (table_name, version))"
+}
+
+// migrate moves table to the layout of the configured schema, recording applied migrations;
+// tables are never migrated back and nothing is migrated when auto_create is none; wide tables have no migrations
+func (conn *connection) migrate(c *config, table string) error {
+	if c.autoCreate == autoCreateNone || c.schema == schemaWide {
+		return nil
+	}
+	logger := log.New()
+	versionTable := qualifiedName(c.database, schemaVersionTable)
+	tableName := qualifiedName(c.database, table)
+	dryRun := c.migrations == migrationsDryRun
+
+	if !dryRun {
+		if _, err := conn.db.Exec(schemaVersionTableQuery(versionTable)); err != nil {
+			logger.Printf("Error: cannot create table %v, err=%v", versionTable, err)
+			return err
+		}
+	}
+
+	state, err := readTableState(conn.db, c.database, table)
+	if err != nil {
+		logger.Printf("Error: cannot read layout of table %v, err=%v", tableName, err)
+		return err
+	}
+	if len(state.columns) == 0 {
+		// the table is missing, it's reported by the check of its columns
+		return nil
+	}
+
+	var recorded sql.NullInt64
+	err = conn.db.QueryRow("SELECT MAX(version) FROM "+versionTable+" WHERE table_name = ?", table).Scan(&recorded)
+	if e, ok := err.(*mysqldriver.MySQLError); err != nil && !(dryRun && ok && e.Number == errNoSuchTable) {
+		logger.Printf("Error: cannot read schema version of table %v, err=%v", tableName, err)
+		return err
+	}
+	version := int(recorded.Int64)
+	if !recorded.Valid {
+		version = detectVersion(state)
+		if !dryRun {
+			if err := conn.recordVersion(versionTable, table, version, "detected layout"); err != nil {
+				return err
+			}
+		}
+	}
+
+	pending := pendingSteps(state, tableName, version, schemaVersion(c.schema))
+	for _, m := range schemaMigrations {
+		queries, ok := pending[m.version]
+		if !ok {
+			if m.version > version && m.version <= schemaVersion(c.schema) && !dryRun {
+				// all steps were applied before an interruption
+				if err := conn.recordVersion(versionTable, table, m.version, m.description); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if dryRun {
+			logger.Printf("Dry run, planned migration of table %v to version %d (%s): %s", tableName, m.version, m.description, strings.Join(queries, "; "))
+			continue
+		}
+
+		logger.Printf("Migrating table %v to version %d (%s)", tableName, m.version, m.description)
+		for _, query := range queries {
+			if _, err := conn.db.Exec(query); err != nil {
+				logger.Printf("Error: cannot migrate table %v to version %d, statement %v failed, err=%v", tableName, m.version, query, err)
+				return err
+			}
+		}
+		if err := conn.recordVersion(versionTable, table, m.version, m.description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (conn *connection) recordVersion(versionTable, table string, version int, description string) error {
+	_, err := conn.db.Exec("INSERT IGNORE INTO "+versionTable+" (table_name, version, description) VALUES (?, ?, ?)", table, version, description)
+	if err != nil {
+		log.New().Printf("Error: cannot record schema version %d of table %v, err=%v", version, table, err)
+	}
+	return err
+}
+
+// readTableState returns the columns and indexes of table, none when it doesn't exist
+func readTableState(db *sql.DB, database, table string) (*tableState, error) {
+	s := &tableState{columns: map[string]string{}, indexes: map[string]bool{}}
+	columns, err := existingColumns(db, database, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range columns {
+		s.columns[strings.ToLower(col.name)] = strings.ToLower(col.dataType)
+	}
+
+	rows, err := db.Query("SELECT DISTINCT INDEX_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index string
+		if err := rows.Scan(&index); err != nil {
+			return nil, err
+		}
+		s.indexes[index] = true
+	}
+	return s, rows.Err()
+}
@@ -0,0 +1,85 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrations(t *testing.T) {
+	Convey("Migrate tables to the layout of their schema", t, func() {
+		legacy := &tableState{
+			columns: map[string]string{"timestamp": "varchar", "source_column": "varchar", "key_column": "varchar", "value_column": "varchar"},
+			indexes: map[string]bool{},
+		}
+		typed := &tableState{
+			columns: map[string]string{"id": "bigint", "timestamp": "datetime", "source_column": "varchar", "key_column": "varchar",
+				"value_numeric": "double", "value_column": "text"},
+			indexes: map[string]bool{"PRIMARY": true, "namespace_timestamp": true, "source_timestamp": true, "timestamp": true},
+		}
+
+		Convey("So versions should be ordered and the typed layout should be the latest", func() {
+			for i := 1; i < len(schemaMigrations); i++ {
+				So(schemaMigrations[i].version, ShouldEqual, schemaMigrations[i-1].version+1)
+			}
+			So(schemaMigrations[0].version, ShouldEqual, versionLegacy+1)
+			So(schemaVersion(schemaLegacy), ShouldEqual, versionLegacy)
+			So(schemaVersion(schemaTyped), ShouldEqual, 4)
+		})
+		Convey("So the version of unrecorded tables should be detected from their layout", func() {
+			So(detectVersion(legacy), ShouldEqual, 1)
+			So(detectVersion(typed), ShouldEqual, 4)
+			delete(typed.indexes, "timestamp")
+			So(detectVersion(typed), ShouldEqual, 3)
+			delete(typed.indexes, "namespace_timestamp")
+			So(detectVersion(typed), ShouldEqual, 2)
+		})
+		Convey("So a legacy table should get every step of every migration", func() {
+			pending := pendingSteps(legacy, "`snap`.`info`", 1, 4)
+			So(pending[2], ShouldHaveLength, 4)
+			So(pending[2][0], ShouldEqual, "ALTER TABLE `snap`.`info` ADD COLUMN value_numeric DOUBLE NULL AFTER key_column")
+			So(pending[3], ShouldResemble, []string{
+				"ALTER TABLE `snap`.`info` ADD INDEX namespace_timestamp (key_column, timestamp)",
+				"ALTER TABLE `snap`.`info` ADD INDEX source_timestamp (source_column, timestamp)",
+			})
+			So(pending[4], ShouldResemble, []string{"ALTER TABLE `snap`.`info` ADD INDEX timestamp (timestamp)"})
+		})
+		Convey("So an interrupted migration should resume with the steps not applied yet", func() {
+			legacy.columns["value_numeric"] = "double"
+			legacy.columns["timestamp"] = "datetime"
+			pending := pendingSteps(legacy, "`snap`.`info`", 1, 3)
+			So(pending[2], ShouldResemble, []string{"ALTER TABLE `snap`.`info` ADD COLUMN id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST"})
+		})
+		Convey("So tables should not be migrated beyond the version of their schema nor back", func() {
+			So(pendingSteps(legacy, "`snap`.`info`", 1, schemaVersion(schemaLegacy)), ShouldBeEmpty)
+			So(pendingSteps(typed, "`snap`.`info`", 4, schemaVersion(schemaLegacy)), ShouldBeEmpty)
+			So(pendingSteps(typed, "`snap`.`info`", 4, schemaVersion(schemaTyped)), ShouldBeEmpty)
+		})
+		Convey("So an unknown migrations mode should return an error", func() {
+			So(checkMigrations(migrationsApply), ShouldBeNil)
+			So(checkMigrations(migrationsDryRun), ShouldBeNil)
+			So(checkMigrations("auto"), ShouldNotBeNil)
+		})
+	})
+}
@@ -21,12 +21,17 @@ package mysql
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -42,25 +47,166 @@ import (
 
 const (
 	name            = "mysql"
-	version         = 8
+	version         = 9
 	pluginType      = plugin.PublisherPluginType
 	usernameDefault = "root"
-	passwordDefault = "root"
+	passwordDefault = ""
 	hostnameDefault = "localhost"
 	tcpPortDefault  = "3306"
 
 	databaseDefault = "SNAP_TEST"
 	tableDefault    = "info"
-	tableColumns    = "(timestamp VARCHAR(200), source_column VARCHAR(200), key_column VARCHAR(200), value_column VARCHAR(200))"
+	schemaDefault   = schemaLegacy
+	tagsDefault     = tagsNone
+
+	onConversionErrorDefault = conversionFail
+
+	batchSizeDefault = 100
+
+	concurrencyDefault = 1
+
+	// connectionIdleTimeout is how long a connection is kept without being used by a publish
+	connectionIdleTimeout = time.Hour
+	// jobsInterval is how often a connection checks in the background whether partitions or jobs are due
+	jobsInterval = time.Minute
+
+	bulkThresholdDefault = 0
+
+	retriesDefault         = 3
+	retryBackoffDefault    = 100
+	retryMaxBackoffDefault = 5000
+
+	maxOpenConnsDefault    = 10
+	maxIdleConnsDefault    = 2
+	connMaxLifetimeDefault = 0
+
+	tlsModeDefault = tlsDisabled
+
+	partitioningDefault    = partitionNone
+	partitionsAheadDefault = 3
+	retentionDefault       = 0
+
+	onDuplicateDefault = duplicateIgnore
+
+	autoCreateDefault = autoCreateAll
+	migrationsDefault = migrationsApply
+
+	spoolPathDefault     = ""
+	spoolMaxSizeDefault  = 100
+	spoolEvictionDefault = evictOldest
+
+	queueSizeDefault     = 10000
+	flushSizeDefault     = 1000
+	flushIntervalDefault = 1000
+	queueFullDefault     = queueBlock
 )
 
 type mysqlPublisher struct {
-	db           *sql.DB
-	dbInsertStmt *sql.Stmt
+	// mu guards the caches below, they're used by Publish and the goroutines of async writers
+	mu sync.Mutex
+	// connections caches an open connection for every distinct config and password
+	connections map[connectionKey]*connection
+	// spools caches the spool of every spooled table, by its directory
+	spools map[string]*spool
+	// writers caches the async writer of every distinct config in async mode, writerConfigs the config it was
+	// last used with, so that flushes use a rotated password
+	writers       map[string]*asyncWriter
+	writerConfigs map[string]*config
+	// closed is set by Close, no async writers are started after it as nothing would flush them
+	closed bool
+}
+
+// connection holds a pool of database connections and the statements prepared for the destination tables
+type connection struct {
+	// ready is closed once the connection is initialized, initErr holds the error of a failed initialization
+	ready   chan struct{}
+	initErr error
+	// users counts the publishes using the connection, usedAt is the last time one of them took or released it;
+	// closing is set when the connection is closed while in use, its last publish closes it then. They're guarded
+	// by the mutex of the publisher
+	users   int
+	usedAt  time.Time
+	closing bool
+
+	db        *sql.DB
+	database  string
+	layout    *layout
+	batchSize int
+
+	// partitioning is nil when tables are not partitioned
+	partitioning        *partitioning
+	partitionsCheckedAt time.Time
+
+	// rollups holds the intervals of rollups, rolledUpAt the last time every one of them ran
+	rollups    []time.Duration
+	rolledUpAt map[time.Duration]time.Time
+	// retention is how long rows are kept, tables which are not partitioned are purged once per purgeInterval
+	retention time.Duration
+	purgedAt  time.Time
+	// stopJobs stops the goroutine maintaining partitions and running jobs, jobsDone is closed once it returned;
+	// both are nil when the connection has no jobs
+	stopJobs chan struct{}
+	jobsDone chan struct{}
+
+	// destinations holds the tables metrics are written to, by their unqualified names
+	destinations map[string]*destination
+	// defaultTable receives metrics which match no route
+	defaultTable string
+	routes       []route
+
+	onConversionError string
+	// atomic wraps every publish in a transaction
+	atomic bool
+	// concurrency is the number of writers sharing large publishes outside transactions
+	concurrency int
+	// bulkThreshold is the number of rows of a table from which they're loaded instead of inserted, 0 when disabled
+	bulkThreshold int
+	// loc is the time zone the driver converts timestamps to, loaded timestamps are converted the same way
+	loc *time.Location
+
+	retry retryPolicy
+	// tlsConfigName references the TLS settings registered with the driver, empty when TLS is disabled
+	tlsConfigName string
+	// deadLetterTableName is the table storing metrics which cannot be converted in dead_letter mode
+	deadLetterTableName string
+}
+
+// destination holds a table of metrics, the statement inserting into it and the tables of tags and metadata of its metrics
+type destination struct {
+	// mu guards the caches of stored tags and metadata, they're shared by concurrent publishes
+	mu sync.Mutex
+
+	insertStmt *sql.Stmt
+	// tableName is quoted and qualified with the database, as USE would switch only one connection of the pool
+	tableName string
+
+	// tagsTableName is the table storing tags when tags are stored in separate tables, tagSetsTableName the one
+	// assigning identifiers to sets of tags
+	tagsTableName    string
+	tagSetsTableName string
+	// storedTags holds the identifiers of sets of tags already stored, by their canonical keys
+	storedTags map[string]int64
+
+	// metadataTableName and elementsTableName are the tables storing metadata of metrics when it's enabled
+	metadataTableName string
+	elementsTableName string
+	// storedMetadata holds the metadata last stored for every namespace
+	storedMetadata map[string]metadata
+
+	// wide holds the columns of namespaces with the wide schema, it's nil otherwise
+	wide *wideTable
+}
+
+// routedRows are the rows of a publish written to one destination, with their metrics
+type routedRows struct {
+	dest    *destination
+	rows    []row
+	metrics []plugin.MetricType
 }
 
 func NewMySQLPublisher() *mysqlPublisher {
-	return &mysqlPublisher{}
+	return &mysqlPublisher{connections: map[connectionKey]*connection{}, spools: map[string]*spool{}, writers: map[string]*asyncWriter{},
+		writerConfigs: map[string]*config{}}
 }
 
 // Publish sends data to a MySQL server
@@ -81,26 +227,330 @@ func (s *mysqlPublisher) Publish(contentType string, content []byte, cfg map[str
 		return errors.New(fmt.Sprintf("Unknown content type '%s'", contentType))
 	}
 
-	if err := s.init(cfg); err != nil {
-		s.db.Close()
+	c, err := parseConfig(cfg)
+	if err != nil {
+		logger.Printf("Error: invalid config, err=%v", err)
+		return err
+	}
+	if c.async {
+		w, err := s.writer(c)
+		if err != nil {
+			logger.Printf("Error: %v", err)
+			return err
+		}
+		return w.enqueue(metrics)
+	}
+	return s.write(c, metrics, logger)
+}
+
+// write publishes metrics to the MySQL table described by c, metrics which cannot be written because of
+// a transient error are spooled when spooling is enabled
+func (s *mysqlPublisher) write(c *config, metrics []plugin.MetricType, logger *log.Logger) error {
+	sp, err := s.spool(c)
+	if err != nil {
+		logger.Printf("Error: cannot open spool in %v, err=%v", c.spoolPath, err)
+		return err
+	}
+
+	conn, err := s.connection(c)
+	if err != nil {
+		return spoolOrFail(sp, metrics, err, logger)
+	}
+	defer s.release(conn)
+
+	if sp != nil {
+		err := sp.replay(func(metrics []plugin.MetricType) ([]plugin.MetricType, error) {
+			return conn.publishMetrics(metrics, logger)
+		})
+		if err != nil && retryable(err) {
+			// keep the order of payloads, this one is spooled after the ones not replayed yet
+			return spoolOrFail(sp, metrics, err, logger)
+		}
+		if err != nil {
+			logger.Printf("Error: cannot replay spool %v, err=%v", sp.dir, err)
+		}
 	}
 
+	if remaining, err := conn.publishMetrics(metrics, logger); err != nil {
+		return spoolOrFail(sp, remaining, err, logger)
+	}
+	return nil
+}
+
+// startJobs maintains partitions and runs the jobs of the connection in the background every jobsInterval,
+// so that they never hold up publishes; failed maintenance and jobs are logged and attempted again on the next tick
+func (conn *connection) startJobs() {
+	if conn.partitioning == nil && len(conn.rollups) == 0 && conn.retention == 0 {
+		return
+	}
+	conn.stopJobs = make(chan struct{})
+	conn.jobsDone = make(chan struct{})
+	go func() {
+		defer close(conn.jobsDone)
+		ticker := time.NewTicker(jobsInterval)
+		defer ticker.Stop()
+		for {
+			conn.maintainPartitions()
+			conn.runJobs()
+			select {
+			case <-ticker.C:
+			case <-conn.stopJobs:
+				return
+			}
+		}
+	}()
+}
+
+// spoolOrFail spools metrics which were not written because of a transient error, other errors are returned
+func spoolOrFail(sp *spool, metrics []plugin.MetricType, err error, logger *log.Logger) error {
+	if sp == nil || !retryable(err) {
+		logger.Printf("Error: Cannot publish incoming metrics to mysql db, err=%v", err)
+		return err
+	}
+	if serr := sp.push(metrics); serr != nil {
+		logger.Printf("Error: Cannot publish incoming metrics to mysql db, err=%v, and cannot spool them, err=%v", err, serr)
+		return err
+	}
+	logger.Printf("Spooled %d metrics in %v, they will be published when mysql db is available, err=%v", len(metrics), sp.dir, err)
+	return nil
+}
+
+// rollbackError reports a publish rolled back in atomic mode
+type rollbackError struct {
+	attempted int
+	err       error
+}
+
+func (e *rollbackError) Error() string {
+	return fmt.Sprintf("Publishing rolled back, none of %d attempted rows were written: %v", e.attempted, e.err)
+}
+
+// publishMetrics converts and writes metrics, in atomic mode within a single transaction;
+// operations failed with a transient error are retried, on error it returns the metrics which were not written
+func (conn *connection) publishMetrics(metrics []plugin.MetricType, logger *log.Logger) ([]plugin.MetricType, error) {
+	rows := make([]row, 0, len(metrics))
+	// converted holds the metrics of rows
+	converted := make([]plugin.MetricType, 0, len(metrics))
+	var failures []metricFailure
 	for _, m := range metrics {
 		key := sliceToString(m.Namespace().Strings())
 		value, err := interfaceToString(m.Data())
 		if err != nil {
-			logger.Printf("Error: Cannot convert incoming data to string, err=%v", err)
-			return err
+			failures = append(failures, metricFailure{metric: m, err: err})
+			continue
 		}
-		_, err = s.dbInsertStmt.Exec(m.Timestamp(), m.Tags()[core.STD_TAG_PLUGIN_RUNNING_ON], key, value)
-		if err != nil {
-			logger.Printf("Error: Cannot publish incoming metric to mysql db, err=%v", err)
-			return err
+		rows = append(rows, row{
+			timestamp: m.Timestamp(),
+			source:    m.Tags()[core.STD_TAG_PLUGIN_RUNNING_ON],
+			key:       key,
+			value:     value,
+			data:      m.Data(),
+			tags:      m.Tags(),
+
+			namespace:   m.Namespace(),
+			unit:        m.Unit(),
+			description: m.Description(),
+		})
+		converted = append(converted, m)
+	}
+
+	if len(failures) > 0 && conn.onConversionError == conversionFail {
+		err := &conversionError{failures}
+		logger.Printf("Error: Cannot convert incoming data to string, err=%v", err)
+		return nil, err
+	}
+
+	groups := conn.route(rows, converted)
+	for _, g := range groups {
+		if g.dest.wide != nil {
+			if err := conn.addWideColumns(g.dest, g.rows); err != nil {
+				return metrics, err
+			}
+		}
+	}
+	if !conn.atomic {
+		if err := conn.handleConversionFailures(retryExecer{conn.db, conn.retry}, failures, logger); err != nil {
+			return metrics, err
+		}
+		for i, g := range groups {
+			remaining, err := conn.writeGroup(g)
+			if err != nil {
+				for _, next := range groups[i+1:] {
+					remaining = append(remaining, next.metrics...)
+				}
+				return remaining, err
+			}
+		}
+		return nil, nil
+	}
+
+	// a deadlock rolls back the whole transaction, so it's retried as a whole
+	err := conn.retry.do(func() error {
+		return conn.publishTx(groups, failures, logger)
+	})
+	if err != nil {
+		return metrics, err
+	}
+	return nil, nil
+}
+
+// route groups rows by their destination tables, in order of their first rows
+func (conn *connection) route(rows []row, metrics []plugin.MetricType) []*routedRows {
+	var groups []*routedRows
+	byTable := map[string]*routedRows{}
+	for i := range rows {
+		table := conn.defaultTable
+		if r := matchRoute(conn.routes, rows[i].namespace.Strings()); r != nil {
+			table = r.table
+		}
+		g, ok := byTable[table]
+		if !ok {
+			g = &routedRows{dest: conn.destinations[table]}
+			byTable[table] = g
+			groups = append(groups, g)
 		}
+		g.rows = append(g.rows, rows[i])
+		g.metrics = append(g.metrics, metrics[i])
+	}
+	return groups
+}
+
+// publishTx writes rows and metrics which cannot be converted within a single transaction
+func (conn *connection) publishTx(groups []*routedRows, failures []metricFailure, logger *log.Logger) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = conn.handleConversionFailures(tx, failures, logger)
+	attempted := 0
+	// tags and metadata are cached once they're committed, rolled back ones are stored again with the next publish
+	var stored []*storedCaches
+	for _, g := range groups {
+		attempted += len(g.rows)
+		if err == nil {
+			var st *storedCaches
+			if st, err = g.dest.writeTagsAndMetadata(tx, g.rows); err == nil {
+				stored = append(stored, st)
+				var stmt *sql.Stmt
+				if g.dest.insertStmt != nil {
+					stmt = tx.Stmt(g.dest.insertStmt)
+				}
+				_, err = conn.writeRows(tx, g.dest, stmt, g.rows, retryPolicy{})
+			}
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		if conn.onConversionError == conversionDeadLetter {
+			attempted += len(failures)
+		}
+		return &rollbackError{attempted: attempted, err: err}
+	}
+	for _, st := range stored {
+		st.apply()
 	}
 	return nil
 }
 
+// writeGroup writes rows routed to a destination outside a transaction, large groups are split across concurrent writers
+// unless they're loaded at once; on error it returns the metrics which were not written
+func (conn *connection) writeGroup(g *routedRows) ([]plugin.MetricType, error) {
+	if conn.concurrency > 1 && len(g.rows) > conn.batchSize && !conn.bulk(g.rows) {
+		return conn.writeParallel(g)
+	}
+	st, err := g.dest.writeTagsAndMetadata(retryExecer{conn.db, conn.retry}, g.rows)
+	if err != nil {
+		return g.metrics, err
+	}
+	st.apply()
+	written, err := conn.writeRows(conn.db, g.dest, g.dest.insertStmt, g.rows, conn.retry)
+	if err != nil {
+		return append([]plugin.MetricType{}, g.metrics[written:]...), err
+	}
+	return nil, nil
+}
+
+// storedCaches holds the tags and metadata written to the tables of a destination, they're added to its caches
+// once they're durable
+type storedCaches struct {
+	dest     *destination
+	tags     map[string]int64
+	metadata map[string]metadata
+}
+
+// apply adds the stored tags and metadata to the caches of the destination
+func (st *storedCaches) apply() {
+	st.dest.mu.Lock()
+	defer st.dest.mu.Unlock()
+	for key, id := range st.tags {
+		st.dest.storedTags[key] = id
+	}
+	for ns, md := range st.metadata {
+		st.dest.storedMetadata[ns] = md
+	}
+}
+
+// writeTagsAndMetadata stores the tags and metadata of rows which are not cached yet in the tables of d
+func (d *destination) writeTagsAndMetadata(ex queryer, rows []row) (*storedCaches, error) {
+	st := &storedCaches{dest: d}
+	var err error
+	if d.tagsTableName != "" {
+		if st.tags, err = d.insertTags(ex, rows); err != nil {
+			return nil, err
+		}
+	}
+	if d.metadataTableName != "" {
+		if st.metadata, err = d.upsertMetadata(ex, rows); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// writeRows writes rows into d, pivoted for wide tables, loaded when they reach bulkThreshold and inserted otherwise;
+// it returns the number of written rows
+func (conn *connection) writeRows(ex execer, d *destination, insertStmt *sql.Stmt, rows []row, retry retryPolicy) (int, error) {
+	if d.wide != nil {
+		return conn.writeWide(ex, d, rows, retry)
+	}
+	if conn.bulk(rows) {
+		return conn.loadRows(ex, d, insertStmt, rows, retry)
+	}
+	return conn.insertRows(ex, d, insertStmt, rows, retry)
+}
+
+// insertRows inserts rows into d in batches of batchSize rows, the last batch may be shorter; it returns the number of
+// written rows
+func (conn *connection) insertRows(ex execer, d *destination, insertStmt *sql.Stmt, rows []row, retry retryPolicy) (int, error) {
+	written := 0
+	for written < len(rows) {
+		n := len(rows) - written
+		if n > conn.batchSize {
+			n = conn.batchSize
+		}
+		batch := conn.layout.args(rows[written : written+n])
+
+		err := retry.do(func() error {
+			var err error
+			if n == conn.batchSize {
+				_, err = insertStmt.Exec(batch...)
+			} else {
+				_, err = ex.Exec(conn.layout.insertQuery(d.tableName, n), batch...)
+			}
+			return err
+		})
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
 func Meta() *plugin.PluginMeta {
 	return plugin.NewPluginMeta(name, version, pluginType, []string{plugin.SnapGOBContentType}, []string{plugin.SnapGOBContentType})
 }
@@ -117,6 +567,14 @@ func (s *mysqlPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	handleErr(err)
 	password.Description = "Password to login to the MySQL server"
 
+	passwordFile, err := cpolicy.NewStringRule("password_file", false, "")
+	handleErr(err)
+	passwordFile.Description = "Path to a file holding the password to login to the MySQL server"
+
+	passwordEnv, err := cpolicy.NewStringRule("password_env", false, "")
+	handleErr(err)
+	passwordEnv.Description = "Name of the environment variable holding the password to login to the MySQL server"
+
 	hostName, err := cpolicy.NewStringRule("hostname", false, hostnameDefault)
 	handleErr(err)
 	password.Description = "The host of MySQL service"
@@ -125,6 +583,30 @@ func (s *mysqlPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	handleErr(err)
 	password.Description = "The host of MySQL service"
 
+	socket, err := cpolicy.NewStringRule("socket", false, "")
+	handleErr(err)
+	socket.Description = "Path to the unix socket of the MySQL server, used instead of hostname and port"
+
+	dsn, err := cpolicy.NewStringRule("dsn", false, "")
+	handleErr(err)
+	dsn.Description = "Data source name of the MySQL server with driver parameters, overrides username, password, hostname, port, socket and database"
+
+	tlsMode, err := cpolicy.NewStringRule("tls_mode", false, tlsModeDefault)
+	handleErr(err)
+	tlsMode.Description = "TLS of connections, 'disabled', 'preferred', 'required' or 'skip-verify'"
+
+	tlsCA, err := cpolicy.NewStringRule("tls_ca", false, "")
+	handleErr(err)
+	tlsCA.Description = "Path to the PEM encoded CA bundle verifying the server certificate in required mode"
+
+	tlsCert, err := cpolicy.NewStringRule("tls_cert", false, "")
+	handleErr(err)
+	tlsCert.Description = "Path to the PEM encoded client certificate"
+
+	tlsKey, err := cpolicy.NewStringRule("tls_key", false, "")
+	handleErr(err)
+	tlsKey.Description = "Path to the PEM encoded key of the client certificate"
+
 	database, err := cpolicy.NewStringRule("database", false, databaseDefault)
 	handleErr(err)
 	database.Description = "The MySQL database that data will be pushed to"
@@ -133,69 +615,531 @@ func (s *mysqlPublisher) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 	handleErr(err)
 	tableName.Description = "The MySQL table within the database where information will be stored"
 
-	config.Add(username, password, hostName, port, database, tableName)
+	batchSize, err := cpolicy.NewIntegerRule("batch_size", false, batchSizeDefault)
+	handleErr(err)
+	batchSize.Description = "Maximum number of metrics inserted with a single multi-row INSERT statement"
+
+	concurrency, err := cpolicy.NewIntegerRule("concurrency", false, concurrencyDefault)
+	handleErr(err)
+	concurrency.Description = "Number of concurrent writers, each with its own connection, sharing publishes of more than batch_size metrics"
+
+	bulkThreshold, err := cpolicy.NewIntegerRule("bulk_threshold", false, bulkThresholdDefault)
+	handleErr(err)
+	bulkThreshold.Description = "Minimum number of metrics routed to a table loaded with LOAD DATA LOCAL INFILE instead of inserted, 0 disables loading"
+
+	maxOpenConns, err := cpolicy.NewIntegerRule("max_open_conns", false, maxOpenConnsDefault)
+	handleErr(err)
+	maxOpenConns.Description = "Maximum number of open connections to the MySQL server, 0 means unlimited"
+
+	maxIdleConns, err := cpolicy.NewIntegerRule("max_idle_conns", false, maxIdleConnsDefault)
+	handleErr(err)
+	maxIdleConns.Description = "Maximum number of idle connections kept in the pool"
+
+	connMaxLifetime, err := cpolicy.NewIntegerRule("conn_max_lifetime", false, connMaxLifetimeDefault)
+	handleErr(err)
+	connMaxLifetime.Description = "Maximum amount of seconds a connection may be reused, 0 means forever"
+
+	routes, err := cpolicy.NewStringRule("routes", false, "")
+	handleErr(err)
+	routes.Description = "Comma separated pattern=table pairs routing metrics with matching namespaces to other tables than tablename"
+
+	autoCreate, err := cpolicy.NewStringRule("auto_create", false, autoCreateDefault)
+	handleErr(err)
+	autoCreate.Description = "Objects created when they're missing, 'all' for the database and tables, 'table' for tables only or 'none'"
+
+	migrations, err := cpolicy.NewStringRule("migrations", false, migrationsDefault)
+	handleErr(err)
+	migrations.Description = "Migrations of tables to the layout of the schema, 'apply' or 'dry_run' to only log the planned statements"
+
+	schema, err := cpolicy.NewStringRule("schema", false, schemaDefault)
+	handleErr(err)
+	schema.Description = "Layout of the table, 'legacy' stores all fields as VARCHAR(200), 'typed' uses typed columns with indexes, 'wide' a column for every namespace"
+
+	tags, err := cpolicy.NewStringRule("tags", false, tagsDefault)
+	handleErr(err)
+	tags.Description = "Storage of metric tags, 'none', 'json' for a JSON column or 'table' for a separate tags table"
+
+	metadata, err := cpolicy.NewBoolRule("metadata", false, false)
+	handleErr(err)
+	metadata.Description = "Store unit, description and dynamic namespace elements of metrics in separate tables"
+
+	uniqueKey, err := cpolicy.NewBoolRule("unique_key", false, false)
+	handleErr(err)
+	uniqueKey.Description = "Add a unique key on namespace, source and timestamp, so that metrics written again are detected"
+
+	onDuplicate, err := cpolicy.NewStringRule("on_duplicate", false, onDuplicateDefault)
+	handleErr(err)
+	onDuplicate.Description = "Handling of metrics written again with unique_key, 'ignore', 'update' or 'error'"
+
+	partitioning, err := cpolicy.NewStringRule("partitioning", false, partitioningDefault)
+	handleErr(err)
+	partitioning.Description = "Partitioning of new metrics tables by timestamp, 'none', 'day' or 'month'"
+
+	partitionsAhead, err := cpolicy.NewIntegerRule("partitions_ahead", false, partitionsAheadDefault)
+	handleErr(err)
+	partitionsAhead.Description = "Number of partitions created ahead of the current one"
+
+	retention, err := cpolicy.NewIntegerRule("retention", false, retentionDefault)
+	handleErr(err)
+	retention.Description = "Number of days rows are kept, partitions are dropped or rows purged after it, 0 keeps them forever"
+
+	rollups, err := cpolicy.NewStringRule("rollups", false, "")
+	handleErr(err)
+	rollups.Description = "Comma separated intervals of rollup tables keeping min, max, avg and count of numeric values, like '1m,1h'"
+
+	onConversionError, err := cpolicy.NewStringRule("on_conversion_error", false, onConversionErrorDefault)
+	handleErr(err)
+	onConversionError.Description = "Handling of metrics which cannot be converted, 'fail', 'skip_and_log' or 'dead_letter'"
+
+	atomic, err := cpolicy.NewBoolRule("atomic", false, false)
+	handleErr(err)
+	atomic.Description = "Write all metrics of a publish within a single transaction, rolled back on any error"
+
+	retries, err := cpolicy.NewIntegerRule("retries", false, retriesDefault)
+	handleErr(err)
+	retries.Description = "Number of retries of operations failed with a transient MySQL error"
+
+	retryBackoff, err := cpolicy.NewIntegerRule("retry_backoff", false, retryBackoffDefault)
+	handleErr(err)
+	retryBackoff.Description = "Delay in milliseconds before the first retry, doubled with every next retry"
+
+	retryMaxBackoff, err := cpolicy.NewIntegerRule("retry_max_backoff", false, retryMaxBackoffDefault)
+	handleErr(err)
+	retryMaxBackoff.Description = "Maximum delay in milliseconds between retries"
+
+	spoolPath, err := cpolicy.NewStringRule("spool_path", false, spoolPathDefault)
+	handleErr(err)
+	spoolPath.Description = "Directory spooling metrics which cannot be published because MySQL is unavailable, empty disables spooling"
+
+	spoolMaxSize, err := cpolicy.NewIntegerRule("spool_max_size", false, spoolMaxSizeDefault)
+	handleErr(err)
+	spoolMaxSize.Description = "Maximum size in megabytes of spooled metrics of a table"
+
+	spoolEviction, err := cpolicy.NewStringRule("spool_eviction", false, spoolEvictionDefault)
+	handleErr(err)
+	spoolEviction.Description = "Handling of a full spool, 'drop_oldest' or 'drop_newest' spooled metrics"
+
+	async, err := cpolicy.NewBoolRule("async", false, false)
+	handleErr(err)
+	async.Description = "Queue metrics in memory and write them in the background, so that Publish doesn't wait for MySQL"
+
+	queueSize, err := cpolicy.NewIntegerRule("queue_size", false, queueSizeDefault)
+	handleErr(err)
+	queueSize.Description = "Maximum number of metrics queued in async mode"
+
+	flushSize, err := cpolicy.NewIntegerRule("flush_size", false, flushSizeDefault)
+	handleErr(err)
+	flushSize.Description = "Number of queued metrics which starts a flush in async mode"
+
+	flushInterval, err := cpolicy.NewIntegerRule("flush_interval", false, flushIntervalDefault)
+	handleErr(err)
+	flushInterval.Description = "Maximum delay in milliseconds between flushes in async mode"
+
+	queueFull, err := cpolicy.NewStringRule("queue_full", false, queueFullDefault)
+	handleErr(err)
+	queueFull.Description = "Handling of a full queue in async mode, 'block', 'drop_oldest' or 'drop_newest' queued metrics"
+
+	config.Add(username, password, passwordFile, passwordEnv, hostName, port, socket, dsn, tlsMode, tlsCA, tlsCert, tlsKey,
+		database, tableName, routes, autoCreate, migrations, schema, tags, metadata, uniqueKey, onDuplicate, partitioning, partitionsAhead, retention, rollups,
+		onConversionError, atomic,
+		retries, retryBackoff, retryMaxBackoff, batchSize, concurrency, bulkThreshold, maxOpenConns, maxIdleConns, connMaxLifetime,
+		spoolPath, spoolMaxSize, spoolEviction, async, queueSize, flushSize, flushInterval, queueFull)
 
 	cp.Add([]string{""}, config)
 	return cp, nil
 }
 
-func (s *mysqlPublisher) init(cfg map[string]ctypes.ConfigValue) error {
+// Close flushes the metrics queued by async writers and closes the connections, it's called when the plugin stops
+func (s *mysqlPublisher) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	writers := s.writers
+	s.writers = map[string]*asyncWriter{}
+	s.mu.Unlock()
+	for _, w := range writers {
+		w.close()
+	}
+
+	// connections still used by publishes are closed once they're released
+	s.mu.Lock()
+	var unused []*connection
+	for _, conn := range s.connections {
+		if conn.users == 0 {
+			unused = append(unused, conn)
+		} else {
+			conn.closing = true
+		}
+	}
+	s.connections = map[connectionKey]*connection{}
+	s.mu.Unlock()
+	for _, conn := range unused {
+		conn.closeWhenReady()
+	}
+	return nil
+}
+
+// writer returns the async writer of the table described by c, it's started on first use;
+// errWriterClosed is returned once the publisher is closed
+func (s *mysqlPublisher) writer(c *config) (*asyncWriter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errWriterClosed
+	}
+	key := c.key()
+	s.writerConfigs[key] = c
+	if w, ok := s.writers[key]; ok {
+		return w, nil
+	}
+
+	w := newAsyncWriter(c.queueSize, c.flushSize, c.flushInterval, c.queueFull, func(metrics []plugin.MetricType) {
+		s.mu.Lock()
+		c := s.writerConfigs[key]
+		s.mu.Unlock()
+		// errors are logged by write, there's no Publish to return them to
+		s.write(c, metrics, log.New())
+	})
+	s.writers[key] = w
+	return w, nil
+}
+
+// connection returns the cached connection for cfg, it is opened and initialized on first use; concurrent
+// publishes with the same config wait for the initialization, the ones with other configs are not held up.
+// The connection must be released once the publish is done with it. Connections unused for connectionIdleTimeout
+// are closed, as the tasks using them were likely stopped or changed, e.g. to a rotated password
+func (s *mysqlPublisher) connection(c *config) (*connection, error) {
+	key := c.connectionKey()
+	s.mu.Lock()
+	var idle []*connection
+	for k, conn := range s.connections {
+		// a connection being initialized is used by the publish initializing it
+		if k != key && conn.users == 0 && now().Sub(conn.usedAt) > connectionIdleTimeout {
+			idle = append(idle, conn)
+			delete(s.connections, k)
+		}
+	}
+	conn, ok := s.connections[key]
+	if !ok {
+		conn = &connection{ready: make(chan struct{})}
+		s.connections[key] = conn
+	}
+	conn.users++
+	conn.usedAt = now()
+	s.mu.Unlock()
+	for _, ic := range idle {
+		go ic.closeWhenReady()
+	}
+	if ok {
+		<-conn.ready
+		if conn.initErr != nil {
+			s.release(conn)
+			return nil, conn.initErr
+		}
+		return conn, nil
+	}
+
+	if err := conn.init(c); err != nil {
+		conn.close()
+		conn.initErr = err
+		// the next publish tries to initialize a new connection
+		s.mu.Lock()
+		if s.connections[key] == conn {
+			delete(s.connections, key)
+		}
+		s.mu.Unlock()
+		close(conn.ready)
+		s.release(conn)
+		return nil, err
+	}
+	close(conn.ready)
+	return conn, nil
+}
+
+// release returns a connection taken by a publish, a connection closed while in use is closed by its last publish
+func (s *mysqlPublisher) release(conn *connection) {
+	s.mu.Lock()
+	conn.users--
+	conn.usedAt = now()
+	closing := conn.users == 0 && conn.closing
+	s.mu.Unlock()
+	if closing {
+		conn.closeWhenReady()
+	}
+}
+
+// spool returns the spool of the table described by c, or nil when spooling is disabled
+func (s *mysqlPublisher) spool(c *config) (*spool, error) {
+	if c.spoolPath == "" {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	dir := spoolDir(c.spoolPath, c)
+	sp, ok := s.spools[dir]
+	if !ok {
+		var err error
+		if sp, err = newSpool(dir, c.spoolMaxSize, c.spoolEviction); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.spools[dir] = sp
+	}
+	s.mu.Unlock()
+	// a replay holds the spool, the publisher isn't held up meanwhile
+	if ok {
+		sp.setLimits(c.spoolMaxSize, c.spoolEviction)
+	}
+	return sp, nil
+}
+
+func (conn *connection) init(c *config) error {
 	var err error
 	logger := log.New()
 
-	url := getMySQLConnectionURL(cfg["username"].(ctypes.ConfigValueStr).Value,
-		cfg["password"].(ctypes.ConfigValueStr).Value,
-		cfg["hostname"].(ctypes.ConfigValueStr).Value,
-		cfg["port"].(ctypes.ConfigValueStr).Value)
+	conn.database = c.database
+	conn.defaultTable = c.tableName
+	conn.routes = c.routes
+	conn.batchSize = c.batchSize
+	conn.onConversionError = c.onConversionError
+	conn.atomic = c.atomic
+	conn.concurrency = c.concurrency
+	conn.bulkThreshold = c.bulkThreshold
+	conn.rollups = c.rollups
+	conn.rolledUpAt = map[time.Duration]time.Time{}
+	conn.retention = c.retention
+	conn.retry = retryPolicy{retries: c.retries, backoff: c.retryBackoff, maxBackoff: c.retryMaxBackoff}
+	if conn.layout, err = newLayout(c.schema, c.tags); err != nil {
+		return err
+	}
+	if c.partitioning != partitionNone {
+		conn.partitioning = &partitioning{interval: c.partitioning, ahead: c.partitionsAhead, retention: c.retention}
+		conn.layout.indexes = partitionedIndexes(conn.layout.indexes)
+	}
+	if c.uniqueKey {
+		conn.layout.setUniqueKey(c.onDuplicate)
+	}
 
-	// Open connection and ping to make sure it works
-	s.db, err = sql.Open("mysql", url)
+	conn.tlsConfigName, err = registerTLSConfig(c)
 	if err != nil {
-		logger.Printf("Error: cannot open %v, err=%v", url, err)
+		logger.Printf("Error: cannot load TLS settings, err=%v", err)
 		return err
 	}
 
-	// test connection
-	err = s.db.Ping()
+	// Open connection and ping to make sure it works
+	url := getMySQLConnectionURL(c, conn.tlsConfigName)
+	err = conn.open(url, c)
+	if fallbackToPlain(c, err) {
+		logger.Printf("Server %v doesn't support TLS, falling back to a plain connection", c.address())
+		conn.db.Close()
+		url = getMySQLConnectionURL(c, "")
+		err = conn.open(url, c)
+	}
 	if err != nil {
-		logger.Printf("Error: cannot establish a connection, err=%v", err)
 		return err
 	}
+	conn.loc = urlLocation(url)
+	if conn.partitioning != nil {
+		conn.partitioning.loc = conn.loc
+	}
 
-	// switch the connection when SelectDB is defined in cfg
-	if _, err = s.db.Exec("USE " + cfg["database"].(ctypes.ConfigValueStr).Value); err != nil {
+	// check that the database exists, create it otherwise
+	if _, err = conn.db.Exec("USE " + quoteIdentifier(c.database)); err != nil {
+		if !unknownDatabase(err) || c.autoCreate != autoCreateAll {
+			logger.Printf("Error: cannot use database %v, err=%v", quoteIdentifier(c.database), err)
+			return err
+		}
+		if _, err = conn.db.Exec("CREATE DATABASE " + quoteIdentifier(c.database)); err != nil {
+			logger.Printf("Error: cannot create a new database %v, err=%v", quoteIdentifier(c.database), err)
+			return err
+		}
+	}
 
-		if _, err = s.db.Exec("CREATE DATABASE " + cfg["database"].(ctypes.ConfigValueStr).Value); err != nil {
-			logger.Printf("Error: cannot create a new database `%v`, err=%v", cfg["database"].(ctypes.ConfigValueStr).Value, err)
+	// Create the destination tables and prepare the statements inserting into them
+	conn.destinations = map[string]*destination{}
+	for _, table := range c.destinationTables() {
+		d, err := conn.prepareDestination(c, table)
+		if err != nil {
 			return err
 		}
+		conn.destinations[table] = d
+	}
+	if err := conn.maintainPartitions(); err != nil {
+		return err
+	}
 
-		// use this already created db
-		if _, err = s.db.Exec("USE " + cfg["database"].(ctypes.ConfigValueStr).Value); err != nil {
+	// Create the table storing metrics which cannot be converted
+	if c.onConversionError == conversionDeadLetter {
+		conn.deadLetterTableName = qualifiedName(c.database, c.tableName+deadLetterTableSuffix)
+		if err = conn.prepareTable(c, c.tableName+deadLetterTableSuffix, deadLetterTableQuery(conn.deadLetterTableName), deadLetterTableColumns); err != nil {
 			return err
 		}
 	}
+	conn.startJobs()
+	return nil
+}
+
+// prepareDestination creates the table receiving metrics, with the tables of their tags and metadata,
+// and prepares the statement inserting a full batch of metrics
+func (conn *connection) prepareDestination(c *config, table string) (*destination, error) {
+	d := &destination{tableName: qualifiedName(c.database, table)}
+
+	// Create the table if it's not already there and migrate it to the layout of the schema
+	createQuery := conn.layout.createQuery(d.tableName)
+	if conn.partitioning != nil {
+		createQuery += " " + conn.partitioning.createClause(now())
+	}
+	if err := conn.createTable(c, table, createQuery); err != nil {
+		return nil, err
+	}
+	if err := conn.migrate(c, table); err != nil {
+		return nil, err
+	}
+	if c.uniqueKey {
+		if err := conn.addUniqueKey(c, table); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.checkTable(c, table, conn.layout.insertColumns()); err != nil {
+		return nil, err
+	}
+
+	// Create the table storing sets of tags
+	if c.tags == tagsTable {
+		d.tagsTableName = qualifiedName(c.database, table+tagsTableSuffix)
+		d.tagSetsTableName = qualifiedName(c.database, table+tagSetsTableSuffix)
+		d.storedTags = map[string]int64{}
+		if err := conn.prepareTable(c, table+tagSetsTableSuffix, tagSetsTableQuery(d.tagSetsTableName), tagSetsTableColumns); err != nil {
+			return nil, err
+		}
+		if err := conn.prepareTable(c, table+tagsTableSuffix, tagsTableQuery(d.tagsTableName), tagsTableColumns); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the tables storing metadata of metrics
+	if c.metadata {
+		d.metadataTableName = qualifiedName(c.database, table+metadataTableSuffix)
+		d.elementsTableName = qualifiedName(c.database, table+elementsTableSuffix)
+		d.storedMetadata = map[string]metadata{}
+		if err := conn.prepareTable(c, table+metadataTableSuffix, metadataTableQuery(d.metadataTableName), metadataTableColumns); err != nil {
+			return nil, err
+		}
+		if err := conn.prepareTable(c, table+elementsTableSuffix, elementsTableQuery(d.elementsTableName), elementsTableColumns); err != nil {
+			return nil, err
+		}
+	}
 
-	// Create the table if it's not already there
-	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS" + " " + cfg["tablename"].(ctypes.ConfigValueStr).Value + " " + tableColumns)
+	// Create the rollup tables
+	for _, interval := range c.rollups {
+		rollupTable := table + rollupSuffix(interval)
+		if err := conn.prepareTable(c, rollupTable, rollupTableQuery(qualifiedName(c.database, rollupTable)), rollupTableColumns); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the columns of namespaces, statements inserting into a wide table depend on the namespaces of metrics
+	if c.schema == schemaWide {
+		d.wide = &wideTable{database: c.database, table: table}
+		if err := d.wide.load(conn.db); err != nil {
+			log.New().Printf("Error: cannot read columns of table %v, err=%v", d.tableName, err)
+			return nil, err
+		}
+		return d, nil
+	}
+
+	// Prepare the statement inserting a full batch of metrics
+	var err error
+	d.insertStmt, err = conn.db.Prepare(conn.layout.insertQuery(d.tableName, conn.batchSize))
 	if err != nil {
-		logger.Printf("Error: cannot create table %v, err=%v", cfg["tablename"].(ctypes.ConfigValueStr).Value, err)
-		return err
+		log.New().Printf("Error: cannot prepare insert db statement, err=%v", err)
+		return nil, err
 	}
+	return d, nil
+}
+
+// open opens the connection pool for url and pings the server to make sure it works
+func (conn *connection) open(url string, c *config) error {
+	logger := log.New()
 
-	// Put the values into the database with the current time
-	s.dbInsertStmt, err = s.db.Prepare("INSERT INTO" + " " + cfg["tablename"].(ctypes.ConfigValueStr).Value + " VALUES( ?, ?, ?, ? )")
+	var err error
+	conn.db, err = sql.Open("mysql", url)
 	if err != nil {
-		fmt.Printf("Error: cannot prepare insert db statement, err=%v", err)
+		logger.Printf("Error: cannot open %v, err=%v", redactURL(url), err)
+		return err
+	}
+	conn.db.SetMaxOpenConns(c.maxOpenConns)
+	conn.db.SetMaxIdleConns(c.maxIdleConns)
+	conn.db.SetConnMaxLifetime(c.connMaxLifetime)
+
+	// test connection
+	if err := conn.retry.do(conn.db.Ping); err != nil {
+		logger.Printf("Error: cannot establish a connection, err=%v", err)
 		return err
 	}
 	return nil
 }
 
-func getMySQLConnectionURL(user, passwd, host, port string) string {
-	// formatting as `user:passwd@tcp(host:port)'
-	mysqlConnectionURL := user + ":" + passwd + "@tcp(" + host + ":" + port + ")/"
-	return mysqlConnectionURL
+// closeWhenReady closes the connection once it's initialized, connections which failed to initialize were closed already
+func (conn *connection) closeWhenReady() {
+	if <-conn.ready; conn.initErr == nil {
+		conn.close()
+	}
+}
+
+// close stops the jobs and releases the prepared statements and the connection pool
+func (conn *connection) close() {
+	if conn.stopJobs != nil {
+		close(conn.stopJobs)
+		<-conn.jobsDone
+	}
+	for _, d := range conn.destinations {
+		if d.insertStmt != nil {
+			d.insertStmt.Close()
+		}
+	}
+	if conn.db != nil {
+		conn.db.Close()
+	}
+	if conn.tlsConfigName != "" {
+		deregisterTLSConfig(conn.tlsConfigName)
+	}
+}
+
+// execer executes statements on the connection pool or within a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer executes statements and queries on the connection pool or within a transaction
+type queryer interface {
+	execer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// execBatches executes the multi-row statement returned by query for args, split to stay below the placeholders limit
+func execBatches(ex execer, args []interface{}, columnsPerRow int, query func(rows int) string) error {
+	maxRows := maxPlaceholders / columnsPerRow
+	for len(args) > 0 {
+		n := len(args) / columnsPerRow
+		if n > maxRows {
+			n = maxRows
+		}
+		if _, err := ex.Exec(query(n), args[:n*columnsPerRow]...); err != nil {
+			return err
+		}
+		args = args[n*columnsPerRow:]
+	}
+	return nil
+}
+
+// placeholders returns the given number of groups of placeholders for a multi-row statement
+func placeholders(rows, columns int) string {
+	group := "( " + strings.TrimSuffix(strings.Repeat("?, ", columns), ", ") + " )"
+	values := make([]string, rows)
+	for i := range values {
+		values[i] = group
+	}
+	return strings.Join(values, ", ")
 }
 
 func handleErr(e error) {
@@ -278,10 +1222,92 @@ func interfaceToString(face interface{}) (string, error) {
 			ret += ", "
 			ret += strconv.FormatFloat(val[i], 'g', -1, 64)
 		}
+	case int8:
+		ret = strconv.FormatInt(int64(val), 10)
+	case int16:
+		ret = strconv.FormatInt(int64(val), 10)
+	case int32:
+		ret = strconv.FormatInt(int64(val), 10)
+	case int64:
+		ret = strconv.FormatInt(val, 10)
+	case uint8:
+		ret = strconv.FormatUint(uint64(val), 10)
+	case uint16:
+		ret = strconv.FormatUint(uint64(val), 10)
+	case uint32:
+		ret = strconv.FormatUint(uint64(val), 10)
+	case float32:
+		ret = strconv.FormatFloat(float64(val), 'g', -1, 32)
+	case bool:
+		ret = strconv.FormatBool(val)
+	case []byte:
+		// raw bytes are stored as text when possible, otherwise base64 encoded
+		if utf8.Valid(val) {
+			ret = string(val)
+		} else {
+			ret = base64.StdEncoding.EncodeToString(val)
+		}
+	case time.Time:
+		ret = val.Format(time.RFC3339Nano)
 	case nil:
 		ret = "nil"
 	default:
-		err = fmt.Errorf("Unsupported type %v (currently supported data type: string, []string, int, []int, uint, []uint, uint64, []uint64, float64, []float64, or nil)", reflect.TypeOf(val))
+		ret, err = reflectToString(val)
 	}
 	return ret, err
 }
+
+// reflectToString formats slices of supported types as a comma separated list, maps and structs are encoded as JSON
+func reflectToString(face interface{}) (string, error) {
+	v := reflect.ValueOf(face)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		values := make([]string, v.Len())
+		for i := range values {
+			str, err := interfaceToString(v.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			values[i] = str
+		}
+		return sliceToString(values), nil
+	case reflect.Map, reflect.Struct, reflect.Ptr:
+		b, err := json.Marshal(face)
+		if err != nil {
+			return "", fmt.Errorf("Unsupported type %v, cannot encode it as JSON: %v", v.Type(), err)
+		}
+		return string(b), nil
+	}
+	return "", fmt.Errorf("Unsupported type %v (currently supported data type: numbers, bool, string, []byte, time.Time, nil, slices of them, maps or structs)", v.Type())
+}
+
+// interfaceToFloat returns numeric data as a float64, the second result is false for other types
+func interfaceToFloat(face interface{}) (float64, bool) {
+	switch val := face.(type) {
+	case int:
+		return float64(val), true
+	case int8:
+		return float64(val), true
+	case int16:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint8:
+		return float64(val), true
+	case uint16:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
@@ -23,7 +23,9 @@ package mysql
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/gob"
+	"sync"
 	"testing"
 	"time"
 
@@ -45,11 +47,17 @@ func TestMySQLPublish(t *testing.T) {
 		*plugin.NewMetricType(core.NewNamespace("test", "string", "slice"), time.Now(), tags, "", []int{1, 2}),
 		*plugin.NewMetricType(core.NewNamespace("test", "uint"), time.Now(), tags, "", uint(1)),
 		*plugin.NewMetricType(core.NewNamespace("test", "uint", "slice"), time.Now(), tags, "", []uint{uint(1), uint(2)}),
+		*plugin.NewMetricType(core.NewNamespace("test", "int64"), time.Now(), tags, "", int64(1)),
+		*plugin.NewMetricType(core.NewNamespace("test", "bool"), time.Now(), tags, "", true),
 	}
 
 	enc := gob.NewEncoder(&buf)
 	enc.Encode(metrics)
 
+	var unsupportedBuf bytes.Buffer
+	unsupported := append(metrics, *plugin.NewMetricType(core.NewNamespace("test", "complex"), time.Now(), tags, "", complex(1, 1)))
+	gob.NewEncoder(&unsupportedBuf).Encode(unsupported)
+
 	Convey("Publish data to existing database", t, func() {
 		config := make(map[string]ctypes.ConfigValue)
 		config["username"] = ctypes.ConfigValueStr{Value: "root"}
@@ -65,6 +73,435 @@ func TestMySQLPublish(t *testing.T) {
 		})
 	})
 
+	Convey("Publish data twice with the same config", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info"}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Both publishes should succeed and share one connection", func() {
+			So(mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg), ShouldBeNil)
+			So(mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg), ShouldBeNil)
+			So(len(mp.connections), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Publish data in several batches", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info"}
+		config["batch_size"] = ctypes.ConfigValueInt{Value: 4}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics with a full and a partial batch should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data to a table with typed schema", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_typed"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data to a table with wide schema", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_wide"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "wide"}
+		config["atomic"] = ctypes.ConfigValueBool{Value: true}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and add a column for every namespace", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			err = mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			for _, conn := range mp.connections {
+				So(conn.destinations["info_wide"].wide.columns, ShouldNotBeEmpty)
+			}
+		})
+	})
+
+	Convey("Publish data to a partitioned table", t, func() {
+		// start every run with a new table
+		db, err := sql.Open("mysql", "root@tcp(localhost:3306)/")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		db.Exec("DROP TABLE IF EXISTS snap_test.info_partitioned")
+
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_partitioned"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["partitioning"] = ctypes.ConfigValueStr{Value: "day"}
+		config["retention"] = ctypes.ConfigValueInt{Value: 7}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data rolled up and purged by the publisher", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_rolled_up"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["rollups"] = ctypes.ConfigValueStr{Value: "1s,1m"}
+		config["retention"] = ctypes.ConfigValueInt{Value: 1}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and run the jobs in the background", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			var conns []*connection
+			for _, conn := range mp.connections {
+				conns = append(conns, conn)
+			}
+			// closing waits for the first run of the jobs
+			mp.Close()
+			for _, conn := range conns {
+				So(conn.purgedAt.IsZero(), ShouldBeFalse)
+				So(conn.rolledUpAt, ShouldContainKey, time.Second)
+			}
+		})
+	})
+
+	Convey("Publish data written again to a table with a unique key", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_unique"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["unique_key"] = ctypes.ConfigValueBool{Value: true}
+		cp, _ := NewMySQLPublisher().GetConfigPolicy()
+
+		for _, policy := range []string{"ignore", "update"} {
+			config["on_duplicate"] = ctypes.ConfigValueStr{Value: policy}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			mp := NewMySQLPublisher()
+			So(mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg), ShouldBeNil)
+			So(mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg), ShouldBeNil)
+		}
+
+		Convey("Publish metrics already stored should fail with the error policy", func() {
+			config["on_duplicate"] = ctypes.ConfigValueStr{Value: "error"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			So(NewMySQLPublisher().Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Publish data with concurrent writers", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_parallel"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["tags"] = ctypes.ConfigValueStr{Value: "table"}
+		config["batch_size"] = ctypes.ConfigValueInt{Value: 2}
+		config["concurrency"] = ctypes.ConfigValueInt{Value: 4}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data loaded with LOAD DATA LOCAL INFILE", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_bulk"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["bulk_threshold"] = ctypes.ConfigValueInt{Value: 1}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and load every metric", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			So(mp.connections, ShouldHaveLength, 1)
+			var db *sql.DB
+			for _, conn := range mp.connections {
+				db = conn.db
+			}
+			var before, after int
+			So(db.QueryRow("SELECT COUNT(*) FROM snap_test.info_bulk").Scan(&before), ShouldBeNil)
+			err = mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			So(db.QueryRow("SELECT COUNT(*) FROM snap_test.info_bulk").Scan(&after), ShouldBeNil)
+			So(after-before, ShouldEqual, len(metrics))
+		})
+	})
+
+	Convey("Publish data concurrently with several configs", t, func() {
+		mp := NewMySQLPublisher()
+		var cfgs []map[string]ctypes.ConfigValue
+		for _, table := range []string{"info_concurrent1", "info_concurrent2"} {
+			config := make(map[string]ctypes.ConfigValue)
+			config["username"] = ctypes.ConfigValueStr{Value: "root"}
+			config["password"] = ctypes.ConfigValueStr{Value: ""}
+			config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+			config["tablename"] = ctypes.ConfigValueStr{Value: table}
+			config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+			config["tags"] = ctypes.ConfigValueStr{Value: "table"}
+			config["metadata"] = ctypes.ConfigValueBool{Value: true}
+			cp, _ := mp.GetConfigPolicy()
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			cfgs = append(cfgs, *cfg)
+		}
+		Convey("Concurrent publishes should succeed and share one connection for every config", func() {
+			var wg sync.WaitGroup
+			errs := make([]error, 10)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), cfgs[i%len(cfgs)])
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				So(err, ShouldBeNil)
+			}
+			So(mp.connections, ShouldHaveLength, 2)
+			So(mp.Close(), ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data in async mode", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_async"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["async"] = ctypes.ConfigValueBool{Value: true}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics should queue them and Close should write them", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			So(mp.writers, ShouldHaveLength, 1)
+			So(mp.Close(), ShouldBeNil)
+			So(mp.connections, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Publish data with tags and metadata stored in separate tables", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_tagged"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["tags"] = ctypes.ConfigValueStr{Value: "table"}
+		config["metadata"] = ctypes.ConfigValueBool{Value: true}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data with a metric which cannot be converted", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info"}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		Convey("Publish in fail mode should throw a conversion error", func() {
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := mp.Publish(plugin.SnapGOBContentType, unsupportedBuf.Bytes(), *cfg)
+			So(err, ShouldHaveSameTypeAs, &conversionError{})
+		})
+		Convey("Publish in skip_and_log mode should succeed and not throw an error", func() {
+			config["on_conversion_error"] = ctypes.ConfigValueStr{Value: "skip_and_log"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := mp.Publish(plugin.SnapGOBContentType, unsupportedBuf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+		Convey("Publish in dead_letter mode should succeed and not throw an error", func() {
+			config["on_conversion_error"] = ctypes.ConfigValueStr{Value: "dead_letter"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := mp.Publish(plugin.SnapGOBContentType, unsupportedBuf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data within a transaction", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_atomic"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["tags"] = ctypes.ConfigValueStr{Value: "table"}
+		config["on_conversion_error"] = ctypes.ConfigValueStr{Value: "dead_letter"}
+		config["atomic"] = ctypes.ConfigValueBool{Value: true}
+		config["batch_size"] = ctypes.ConfigValueInt{Value: 4}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, unsupportedBuf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data over TLS when the server supports it", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info"}
+		config["tls_mode"] = ctypes.ConfigValueStr{Value: "preferred"}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data to a database and table with names which must be quoted", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap-test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "select"}
+		config["tags"] = ctypes.ConfigValueStr{Value: "table"}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data without creating the database and tables", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["auto_create"] = ctypes.ConfigValueStr{Value: "none"}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		Convey("Publish metrics to an existing table should succeed and not throw an error", func() {
+			config["tablename"] = ctypes.ConfigValueStr{Value: "info"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+		Convey("Publish metrics to a missing table should throw an error", func() {
+			config["tablename"] = ctypes.ConfigValueStr{Value: "info_missing"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Publish metrics to a table with an incompatible layout should throw an error", func() {
+			config["tablename"] = ctypes.ConfigValueStr{Value: "info"}
+			config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Publish data to a legacy table migrated to the typed schema", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_migrated"}
+
+		// start every run with a legacy table
+		db, err := sql.Open("mysql", "root@tcp(localhost:3306)/")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		db.Exec("DROP TABLE IF EXISTS snap_test.info_migrated")
+		db.Exec("DELETE FROM snap_test.snap_schema_version WHERE table_name = 'info_migrated'")
+
+		cp, _ := NewMySQLPublisher().GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		So(NewMySQLPublisher().Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg), ShouldBeNil)
+
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		Convey("Publish in dry_run mode should throw an error about the incompatible layout", func() {
+			config["migrations"] = ctypes.ConfigValueStr{Value: "dry_run"}
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := NewMySQLPublisher().Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Publish after applying migrations should succeed and not throw an error", func() {
+			cfg, _ := cp.Get([]string{""}).Process(config)
+			err := NewMySQLPublisher().Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Publish data routed to several tables", t, func() {
+		config := make(map[string]ctypes.ConfigValue)
+		config["username"] = ctypes.ConfigValueStr{Value: "root"}
+		config["password"] = ctypes.ConfigValueStr{Value: ""}
+		config["database"] = ctypes.ConfigValueStr{Value: "snap_test"}
+		config["tablename"] = ctypes.ConfigValueStr{Value: "info_routed"}
+		config["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+		config["routes"] = ctypes.ConfigValueStr{Value: "/test/string/*=info_routed_strings,/test/uint*=info_routed_uints"}
+		config["atomic"] = ctypes.ConfigValueBool{Value: true}
+		mp := NewMySQLPublisher()
+		cp, _ := mp.GetConfigPolicy()
+		cfg, _ := cp.Get([]string{""}).Process(config)
+		Convey("Publish metrics to MySQL instance should succeed and not throw an error", func() {
+			err := mp.Publish(plugin.SnapGOBContentType, buf.Bytes(), *cfg)
+			So(err, ShouldBeNil)
+			So(mp.connections, ShouldHaveLength, 1)
+			for _, conn := range mp.connections {
+				So(conn.destinations, ShouldHaveLength, 3)
+			}
+		})
+	})
+
 	Convey("Publish data to non-existing database", t, func() {
 		config := make(map[string]ctypes.ConfigValue)
 		config["username"] = ctypes.ConfigValueStr{Value: "root"}
@@ -22,7 +22,9 @@ limitations under the License.
 package mysql
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/intelsdi-x/snap/control/plugin"
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
@@ -68,6 +70,44 @@ func TestMySQLPlugin(t *testing.T) {
 				testConfig["port"] = ctypes.ConfigValueStr{Value: "33061"}
 				testConfig["database"] = ctypes.ConfigValueStr{Value: "SNAP_TEST1"}
 				testConfig["tablename"] = ctypes.ConfigValueStr{Value: "info1"}
+				testConfig["routes"] = ctypes.ConfigValueStr{Value: "/intel/procfs/*=procfs_metrics"}
+				testConfig["auto_create"] = ctypes.ConfigValueStr{Value: "none"}
+				testConfig["migrations"] = ctypes.ConfigValueStr{Value: "dry_run"}
+				testConfig["schema"] = ctypes.ConfigValueStr{Value: "typed"}
+				testConfig["tags"] = ctypes.ConfigValueStr{Value: "json"}
+				testConfig["metadata"] = ctypes.ConfigValueBool{Value: true}
+				testConfig["unique_key"] = ctypes.ConfigValueBool{Value: true}
+				testConfig["async"] = ctypes.ConfigValueBool{Value: true}
+				testConfig["concurrency"] = ctypes.ConfigValueInt{Value: 4}
+				testConfig["queue_size"] = ctypes.ConfigValueInt{Value: 500}
+				testConfig["flush_size"] = ctypes.ConfigValueInt{Value: 50}
+				testConfig["flush_interval"] = ctypes.ConfigValueInt{Value: 200}
+				testConfig["queue_full"] = ctypes.ConfigValueStr{Value: "drop_newest"}
+				testConfig["on_duplicate"] = ctypes.ConfigValueStr{Value: "update"}
+				testConfig["partitioning"] = ctypes.ConfigValueStr{Value: "day"}
+				testConfig["partitions_ahead"] = ctypes.ConfigValueInt{Value: 7}
+				testConfig["retention"] = ctypes.ConfigValueInt{Value: 30}
+				testConfig["rollups"] = ctypes.ConfigValueStr{Value: "1m,1h"}
+				testConfig["on_conversion_error"] = ctypes.ConfigValueStr{Value: "dead_letter"}
+				testConfig["atomic"] = ctypes.ConfigValueBool{Value: true}
+				testConfig["retries"] = ctypes.ConfigValueInt{Value: 5}
+				testConfig["retry_backoff"] = ctypes.ConfigValueInt{Value: 10}
+				testConfig["retry_max_backoff"] = ctypes.ConfigValueInt{Value: 1000}
+				testConfig["batch_size"] = ctypes.ConfigValueInt{Value: 500}
+				testConfig["max_open_conns"] = ctypes.ConfigValueInt{Value: 20}
+				testConfig["max_idle_conns"] = ctypes.ConfigValueInt{Value: 5}
+				testConfig["conn_max_lifetime"] = ctypes.ConfigValueInt{Value: 60}
+				testConfig["password_file"] = ctypes.ConfigValueStr{Value: "/etc/snap/mysql-password"}
+				testConfig["password_env"] = ctypes.ConfigValueStr{Value: "SNAP_MYSQL_PASSWORD"}
+				testConfig["socket"] = ctypes.ConfigValueStr{Value: "/var/run/mysqld/mysqld.sock"}
+				testConfig["dsn"] = ctypes.ConfigValueStr{Value: "root1:root1@tcp(localhost1:33061)/SNAP_TEST1?timeout=5s"}
+				testConfig["tls_mode"] = ctypes.ConfigValueStr{Value: "required"}
+				testConfig["tls_ca"] = ctypes.ConfigValueStr{Value: "/etc/mysql/ca.pem"}
+				testConfig["tls_cert"] = ctypes.ConfigValueStr{Value: "/etc/mysql/client-cert.pem"}
+				testConfig["tls_key"] = ctypes.ConfigValueStr{Value: "/etc/mysql/client-key.pem"}
+				testConfig["spool_path"] = ctypes.ConfigValueStr{Value: "/var/spool/snap"}
+				testConfig["spool_max_size"] = ctypes.ConfigValueInt{Value: 10}
+				testConfig["spool_eviction"] = ctypes.ConfigValueStr{Value: "drop_newest"}
 
 				cfg, errs := configPolicy.Get([]string{""}).Process(testConfig)
 
@@ -82,6 +122,44 @@ func TestMySQLPlugin(t *testing.T) {
 					So((*cfg)["port"].(ctypes.ConfigValueStr).Value, ShouldEqual, "33061")
 					So((*cfg)["database"].(ctypes.ConfigValueStr).Value, ShouldEqual, "SNAP_TEST1")
 					So((*cfg)["tablename"].(ctypes.ConfigValueStr).Value, ShouldEqual, "info1")
+					So((*cfg)["routes"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/intel/procfs/*=procfs_metrics")
+					So((*cfg)["auto_create"].(ctypes.ConfigValueStr).Value, ShouldEqual, "none")
+					So((*cfg)["migrations"].(ctypes.ConfigValueStr).Value, ShouldEqual, "dry_run")
+					So((*cfg)["schema"].(ctypes.ConfigValueStr).Value, ShouldEqual, "typed")
+					So((*cfg)["tags"].(ctypes.ConfigValueStr).Value, ShouldEqual, "json")
+					So((*cfg)["metadata"].(ctypes.ConfigValueBool).Value, ShouldBeTrue)
+					So((*cfg)["unique_key"].(ctypes.ConfigValueBool).Value, ShouldBeTrue)
+					So((*cfg)["async"].(ctypes.ConfigValueBool).Value, ShouldBeTrue)
+					So((*cfg)["concurrency"].(ctypes.ConfigValueInt).Value, ShouldEqual, 4)
+					So((*cfg)["queue_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 500)
+					So((*cfg)["flush_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 50)
+					So((*cfg)["flush_interval"].(ctypes.ConfigValueInt).Value, ShouldEqual, 200)
+					So((*cfg)["queue_full"].(ctypes.ConfigValueStr).Value, ShouldEqual, "drop_newest")
+					So((*cfg)["on_duplicate"].(ctypes.ConfigValueStr).Value, ShouldEqual, "update")
+					So((*cfg)["partitioning"].(ctypes.ConfigValueStr).Value, ShouldEqual, "day")
+					So((*cfg)["partitions_ahead"].(ctypes.ConfigValueInt).Value, ShouldEqual, 7)
+					So((*cfg)["retention"].(ctypes.ConfigValueInt).Value, ShouldEqual, 30)
+					So((*cfg)["rollups"].(ctypes.ConfigValueStr).Value, ShouldEqual, "1m,1h")
+					So((*cfg)["on_conversion_error"].(ctypes.ConfigValueStr).Value, ShouldEqual, "dead_letter")
+					So((*cfg)["atomic"].(ctypes.ConfigValueBool).Value, ShouldBeTrue)
+					So((*cfg)["retries"].(ctypes.ConfigValueInt).Value, ShouldEqual, 5)
+					So((*cfg)["retry_backoff"].(ctypes.ConfigValueInt).Value, ShouldEqual, 10)
+					So((*cfg)["retry_max_backoff"].(ctypes.ConfigValueInt).Value, ShouldEqual, 1000)
+					So((*cfg)["batch_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 500)
+					So((*cfg)["max_open_conns"].(ctypes.ConfigValueInt).Value, ShouldEqual, 20)
+					So((*cfg)["max_idle_conns"].(ctypes.ConfigValueInt).Value, ShouldEqual, 5)
+					So((*cfg)["conn_max_lifetime"].(ctypes.ConfigValueInt).Value, ShouldEqual, 60)
+					So((*cfg)["password_file"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/etc/snap/mysql-password")
+					So((*cfg)["password_env"].(ctypes.ConfigValueStr).Value, ShouldEqual, "SNAP_MYSQL_PASSWORD")
+					So((*cfg)["socket"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/var/run/mysqld/mysqld.sock")
+					So((*cfg)["dsn"].(ctypes.ConfigValueStr).Value, ShouldEqual, "root1:root1@tcp(localhost1:33061)/SNAP_TEST1?timeout=5s")
+					So((*cfg)["tls_mode"].(ctypes.ConfigValueStr).Value, ShouldEqual, "required")
+					So((*cfg)["tls_ca"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/etc/mysql/ca.pem")
+					So((*cfg)["tls_cert"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/etc/mysql/client-cert.pem")
+					So((*cfg)["tls_key"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/etc/mysql/client-key.pem")
+					So((*cfg)["spool_path"].(ctypes.ConfigValueStr).Value, ShouldEqual, "/var/spool/snap")
+					So((*cfg)["spool_max_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 10)
+					So((*cfg)["spool_eviction"].(ctypes.ConfigValueStr).Value, ShouldEqual, "drop_newest")
 				})
 
 				Convey("So testConfig processing should return no errors", func() {
@@ -100,11 +178,49 @@ func TestMySQLPlugin(t *testing.T) {
 
 				Convey("so parameters should have correct values", func() {
 					So((*cfg)["username"].(ctypes.ConfigValueStr).Value, ShouldEqual, "root")
-					So((*cfg)["password"].(ctypes.ConfigValueStr).Value, ShouldEqual, "root")
+					So((*cfg)["password"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["password_file"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["password_env"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
 					So((*cfg)["hostname"].(ctypes.ConfigValueStr).Value, ShouldEqual, "localhost")
 					So((*cfg)["port"].(ctypes.ConfigValueStr).Value, ShouldEqual, "3306")
 					So((*cfg)["database"].(ctypes.ConfigValueStr).Value, ShouldEqual, "SNAP_TEST")
 					So((*cfg)["tablename"].(ctypes.ConfigValueStr).Value, ShouldEqual, "info")
+					So((*cfg)["routes"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["auto_create"].(ctypes.ConfigValueStr).Value, ShouldEqual, "all")
+					So((*cfg)["migrations"].(ctypes.ConfigValueStr).Value, ShouldEqual, "apply")
+					So((*cfg)["schema"].(ctypes.ConfigValueStr).Value, ShouldEqual, "legacy")
+					So((*cfg)["tags"].(ctypes.ConfigValueStr).Value, ShouldEqual, "none")
+					So((*cfg)["metadata"].(ctypes.ConfigValueBool).Value, ShouldBeFalse)
+					So((*cfg)["unique_key"].(ctypes.ConfigValueBool).Value, ShouldBeFalse)
+					So((*cfg)["async"].(ctypes.ConfigValueBool).Value, ShouldBeFalse)
+					So((*cfg)["concurrency"].(ctypes.ConfigValueInt).Value, ShouldEqual, 1)
+					So((*cfg)["queue_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 10000)
+					So((*cfg)["flush_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 1000)
+					So((*cfg)["flush_interval"].(ctypes.ConfigValueInt).Value, ShouldEqual, 1000)
+					So((*cfg)["queue_full"].(ctypes.ConfigValueStr).Value, ShouldEqual, "block")
+					So((*cfg)["on_duplicate"].(ctypes.ConfigValueStr).Value, ShouldEqual, "ignore")
+					So((*cfg)["partitioning"].(ctypes.ConfigValueStr).Value, ShouldEqual, "none")
+					So((*cfg)["partitions_ahead"].(ctypes.ConfigValueInt).Value, ShouldEqual, 3)
+					So((*cfg)["retention"].(ctypes.ConfigValueInt).Value, ShouldEqual, 0)
+					So((*cfg)["rollups"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["on_conversion_error"].(ctypes.ConfigValueStr).Value, ShouldEqual, "fail")
+					So((*cfg)["atomic"].(ctypes.ConfigValueBool).Value, ShouldBeFalse)
+					So((*cfg)["retries"].(ctypes.ConfigValueInt).Value, ShouldEqual, 3)
+					So((*cfg)["retry_backoff"].(ctypes.ConfigValueInt).Value, ShouldEqual, 100)
+					So((*cfg)["retry_max_backoff"].(ctypes.ConfigValueInt).Value, ShouldEqual, 5000)
+					So((*cfg)["batch_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 100)
+					So((*cfg)["max_open_conns"].(ctypes.ConfigValueInt).Value, ShouldEqual, 10)
+					So((*cfg)["max_idle_conns"].(ctypes.ConfigValueInt).Value, ShouldEqual, 2)
+					So((*cfg)["conn_max_lifetime"].(ctypes.ConfigValueInt).Value, ShouldEqual, 0)
+					So((*cfg)["socket"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["dsn"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["tls_mode"].(ctypes.ConfigValueStr).Value, ShouldEqual, "disabled")
+					So((*cfg)["tls_ca"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["tls_cert"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["tls_key"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["spool_path"].(ctypes.ConfigValueStr).Value, ShouldEqual, "")
+					So((*cfg)["spool_max_size"].(ctypes.ConfigValueInt).Value, ShouldEqual, 100)
+					So((*cfg)["spool_eviction"].(ctypes.ConfigValueStr).Value, ShouldEqual, "drop_oldest")
 				})
 
 				Convey("So testConfig processing should return no errors", func() {
@@ -115,6 +231,13 @@ func TestMySQLPlugin(t *testing.T) {
 	})
 }
 
+func TestRollbackError(t *testing.T) {
+	Convey("Rolled back publish should report attempted rows", t, func() {
+		err := &rollbackError{attempted: 42, err: errors.New("Deadlock found")}
+		So(err.Error(), ShouldEqual, "Publishing rolled back, none of 42 attempted rows were written: Deadlock found")
+	})
+}
+
 func TestInterfaceToString(t *testing.T) {
 	Convey("Return properly formatted values", t, func() {
 		Convey("Slice of strings should be formatted", func() {
@@ -237,6 +360,68 @@ func TestInterfaceToString(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(str, ShouldEqual, "nil")
 		})
+		Convey("So sized ints and uints should be formatted", func() {
+			for _, v := range []interface{}{int8(-1), int16(-1), int32(-1), int64(-1)} {
+				str, err := interfaceToString(v)
+				So(err, ShouldBeNil)
+				So(str, ShouldEqual, "-1")
+			}
+			for _, v := range []interface{}{uint8(1), uint16(1), uint32(1)} {
+				str, err := interfaceToString(v)
+				So(err, ShouldBeNil)
+				So(str, ShouldEqual, "1")
+			}
+		})
+		Convey("So a float32 should be formatted", func() {
+			str, err := interfaceToString(float32(1.25))
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "1.25")
+		})
+		Convey("So a bool should be formatted", func() {
+			str, err := interfaceToString(true)
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "true")
+		})
+		Convey("So bytes should be formatted as text or base64", func() {
+			str, err := interfaceToString([]byte("foo"))
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "foo")
+			str, err = interfaceToString([]byte{0xff, 0xfe})
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "//4=")
+		})
+		Convey("So a time should be formatted", func() {
+			str, err := interfaceToString(time.Date(2016, 10, 28, 12, 0, 0, 500, time.UTC))
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "2016-10-28T12:00:00.0000005Z")
+		})
+		Convey("So slices of other types should be formatted", func() {
+			str, err := interfaceToString([]int64{1, 2})
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "1, 2")
+			str, err = interfaceToString([]bool{true, false})
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, "true, false")
+		})
+		Convey("So maps and structs should be encoded as JSON", func() {
+			str, err := interfaceToString(map[string]int{"a": 1})
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, `{"a":1}`)
+			str, err = interfaceToString(struct {
+				Name  string
+				Value float64
+			}{"a", 1.5})
+			So(err, ShouldBeNil)
+			So(str, ShouldEqual, `{"Name":"a","Value":1.5}`)
+		})
+		Convey("So a slice of an unsupported type should return an error", func() {
+			_, err := interfaceToString([]foo{1})
+			So(err.Error(), ShouldStartWith, "Unsupported type")
+		})
+		Convey("So a value which cannot be encoded as JSON should return an error", func() {
+			_, err := interfaceToString(map[string]interface{}{"a": make(chan int)})
+			So(err.Error(), ShouldStartWith, "Unsupported type")
+		})
 		Convey("So an unsupported type should return an error", func() {
 			str, err := interfaceToString(foo(1))
 			So(str, ShouldBeBlank)
@@ -244,3 +429,21 @@ func TestInterfaceToString(t *testing.T) {
 		})
 	})
 }
+
+func TestInterfaceToFloat(t *testing.T) {
+	Convey("Return numeric values as float64", t, func() {
+		Convey("So numbers should be converted", func() {
+			for _, v := range []interface{}{int(2), int8(2), int16(2), int32(2), int64(2), uint(2), uint8(2), uint16(2), uint32(2), uint64(2), float32(2), float64(2)} {
+				f, ok := interfaceToFloat(v)
+				So(ok, ShouldBeTrue)
+				So(f, ShouldEqual, 2)
+			}
+		})
+		Convey("So other types should not be converted", func() {
+			for _, v := range []interface{}{"2", true, []int{2}, nil} {
+				_, ok := interfaceToFloat(v)
+				So(ok, ShouldBeFalse)
+			}
+		})
+	})
+}
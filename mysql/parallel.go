@@ -0,0 +1,132 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+)
+
+// writersError reports the failures of concurrent writers
+type writersError struct {
+	writers int
+	errs    []error
+}
+
+func (e *writersError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d writers failed: %s", len(e.errs), e.writers, strings.Join(msgs, "; "))
+}
+
+// shards splits rows into up to n lists of indexes written by concurrent writers, every writer gets whole batches but
+// the last one; rows of a wide table merged into the same row are kept together, so that writers never update the same rows
+func shards(rows []row, n, batchSize int, wide bool) [][]int {
+	// units are the groups of rows which must be written by the same writer
+	var units [][]int
+	if wide {
+		index := map[string]int{}
+		for i := range rows {
+			id := wideRowID(&rows[i])
+			u, ok := index[id]
+			if !ok {
+				u = len(units)
+				index[id] = u
+				units = append(units, nil)
+			}
+			units[u] = append(units[u], i)
+		}
+	} else {
+		for i := range rows {
+			units = append(units, []int{i})
+		}
+	}
+
+	perWriter := (len(units) + n - 1) / n
+	if perWriter%batchSize != 0 {
+		perWriter += batchSize - perWriter%batchSize
+	}
+	var result [][]int
+	for len(units) > 0 {
+		k := perWriter
+		if k > len(units) {
+			k = len(units)
+		}
+		var shard []int
+		for _, u := range units[:k] {
+			shard = append(shard, u...)
+		}
+		result = append(result, shard)
+		units = units[k:]
+	}
+	return result
+}
+
+// writeParallel writes the rows of a group with up to concurrency writers, each using its own connection of the pool;
+// tags and metadata are stored first, so that writers never share their caches. On error it returns the metrics which
+// were not written, in order
+func (conn *connection) writeParallel(g *routedRows) ([]plugin.MetricType, error) {
+	st, err := g.dest.writeTagsAndMetadata(retryExecer{conn.db, conn.retry}, g.rows)
+	if err != nil {
+		return g.metrics, err
+	}
+	st.apply()
+
+	parts := shards(g.rows, conn.concurrency, conn.batchSize, g.dest.wide != nil)
+	written := make([]int, len(parts))
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part []int) {
+			defer wg.Done()
+			rows := make([]row, len(part))
+			for j, k := range part {
+				rows[j] = g.rows[k]
+			}
+			written[i], errs[i] = conn.writeRows(conn.db, g.dest, g.dest.insertStmt, rows, conn.retry)
+		}(i, part)
+	}
+	wg.Wait()
+
+	failed := &writersError{writers: len(parts)}
+	var unwritten []int
+	for i, err := range errs {
+		if err != nil {
+			failed.errs = append(failed.errs, err)
+			unwritten = append(unwritten, parts[i][written[i]:]...)
+		}
+	}
+	if len(failed.errs) == 0 {
+		return nil, nil
+	}
+	sort.Ints(unwritten)
+	remaining := make([]plugin.MetricType, len(unwritten))
+	for i, k := range unwritten {
+		remaining[i] = g.metrics[k]
+	}
+	return remaining, failed
+}
@@ -0,0 +1,226 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShards(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC)
+	rows := func(n int) []row {
+		rows := make([]row, n)
+		for i := range rows {
+			rows[i] = row{timestamp: ts.Add(time.Duration(i/3) * time.Second), source: "host"}
+		}
+		return rows
+	}
+
+	Convey("Split rows across concurrent writers", t, func() {
+		Convey("So every writer should get whole batches but the last one", func() {
+			parts := shards(rows(10), 3, 2, false)
+			So(parts, ShouldResemble, [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}})
+			parts = shards(rows(5), 4, 2, false)
+			So(parts, ShouldResemble, [][]int{{0, 1}, {2, 3}, {4}})
+		})
+		Convey("So rows of a wide row should be written by the same writer", func() {
+			parts := shards(rows(9), 2, 1, true)
+			So(parts, ShouldResemble, [][]int{{0, 1, 2, 3, 4, 5}, {6, 7, 8}})
+		})
+		Convey("So rows sharing the primary key of a wide row should be written by the same writer", func() {
+			rows := []row{
+				{timestamp: ts, source: "host"},
+				{timestamp: ts.Add(time.Nanosecond), source: "host"},
+				{timestamp: ts.Add(999 * time.Nanosecond), source: "HOST"},
+				{timestamp: ts.Add(time.Microsecond), source: "host"},
+			}
+			So(wideRowID(&rows[1]), ShouldEqual, wideRowID(&rows[0]))
+			So(wideRowID(&rows[2]), ShouldEqual, wideRowID(&rows[0]))
+			So(shards(rows, 2, 1, true), ShouldResemble, [][]int{{0, 1, 2}, {3}})
+		})
+	})
+
+	Convey("Errors of concurrent writers", t, func() {
+		deadlock := &mysqldriver.MySQLError{Number: errDeadlock, Message: "Deadlock found"}
+		err := &writersError{writers: 3, errs: []error{deadlock, mysqldriver.ErrInvalidConn}}
+		So(err.Error(), ShouldStartWith, "2 of 3 writers failed: Error 1213")
+		So(retryable(err), ShouldBeTrue)
+		err.errs = append(err.errs, errors.New("syntax error"))
+		So(retryable(err), ShouldBeFalse)
+	})
+}
+
+func TestPublisherState(t *testing.T) {
+	Convey("Share the state of the publisher between goroutines", t, func() {
+		dir, err := ioutil.TempDir("", "snap-mysql-state")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		cfg := defaultTestConfig()
+		cfg["spool_path"] = ctypes.ConfigValueStr{Value: dir}
+		cfg["async"] = ctypes.ConfigValueBool{Value: true}
+		c, err := parseConfig(cfg)
+		So(err, ShouldBeNil)
+
+		s := NewMySQLPublisher()
+		cached := &connection{ready: make(chan struct{})}
+		close(cached.ready)
+		s.connections[c.connectionKey()] = cached
+
+		var wg sync.WaitGroup
+		conns := make([]*connection, 20)
+		spools := make([]*spool, 20)
+		writers := make([]*asyncWriter, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				conns[i], _ = s.connection(c)
+				s.release(conns[i])
+				spools[i], _ = s.spool(c)
+				writers[i], _ = s.writer(c)
+			}(i)
+		}
+		wg.Wait()
+
+		So(s.spools, ShouldHaveLength, 1)
+		So(s.writers, ShouldHaveLength, 1)
+		for i := range conns {
+			So(conns[i], ShouldEqual, cached)
+			So(spools[i], ShouldEqual, spools[0])
+			So(writers[i], ShouldEqual, writers[0])
+		}
+		So(cached.users, ShouldEqual, 0)
+
+		So(s.Close(), ShouldBeNil)
+		So(s.writers, ShouldBeEmpty)
+		So(s.connections, ShouldBeEmpty)
+
+		Convey("So no writer should be started once the publisher is closed", func() {
+			_, err := s.writer(c)
+			So(err, ShouldEqual, errWriterClosed)
+			So(s.writers, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Initialize a connection once for concurrent publishes", t, func() {
+		cfg := defaultTestConfig()
+		cfg["hostname"] = ctypes.ConfigValueStr{Value: "127.0.0.1"}
+		cfg["port"] = ctypes.ConfigValueStr{Value: "1"}
+		cfg["retries"] = ctypes.ConfigValueInt{Value: 0}
+		c, err := parseConfig(cfg)
+		So(err, ShouldBeNil)
+
+		s := NewMySQLPublisher()
+		var wg sync.WaitGroup
+		errs := make([]error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = s.connection(c)
+			}(i)
+		}
+		wg.Wait()
+
+		Convey("So every publish should get the error of a failed initialization", func() {
+			for i := range errs {
+				So(errs[i], ShouldNotBeNil)
+			}
+			So(s.connections, ShouldBeEmpty)
+		})
+		Convey("So publishes should wait for a pending initialization", func() {
+			pending := &connection{ready: make(chan struct{}), users: 1}
+			s.connections[c.connectionKey()] = pending
+			got := make(chan *connection)
+			go func() {
+				conn, _ := s.connection(c)
+				got <- conn
+			}()
+			select {
+			case <-got:
+				t.Error("connection returned before the initialization completed")
+			case <-time.After(10 * time.Millisecond):
+			}
+			close(pending.ready)
+			So(<-got, ShouldEqual, pending)
+		})
+		Convey("So a connection opened with another password should be kept for the tasks using it", func() {
+			other := &connection{ready: make(chan struct{}), users: 1, usedAt: time.Now()}
+			close(other.ready)
+			otherKey := connectionKey{config: c.key()}
+			s.connections[otherKey] = other
+			_, err := s.connection(c)
+			So(err, ShouldNotBeNil)
+			So(s.connections, ShouldContainKey, otherKey)
+			So(s.connections[otherKey], ShouldEqual, other)
+		})
+		Convey("So connections unused for a while should be closed", func() {
+			defer func() { now = time.Now }()
+			idleKey := connectionKey{config: "idle"}
+			idle := &connection{ready: make(chan struct{}), usedAt: time.Now()}
+			close(idle.ready)
+			s.connections[idleKey] = idle
+			busyKey := connectionKey{config: "busy"}
+			busy := &connection{ready: make(chan struct{}), users: 1, usedAt: time.Now()}
+			close(busy.ready)
+			s.connections[busyKey] = busy
+			s.connection(c)
+			So(s.connections, ShouldContainKey, idleKey)
+
+			now = func() time.Time { return time.Now().Add(connectionIdleTimeout + time.Minute) }
+			s.connection(c)
+			So(s.connections, ShouldNotContainKey, idleKey)
+			So(s.connections, ShouldContainKey, busyKey)
+		})
+		Convey("So a connection closed while in use should be closed by its last publish", func() {
+			used := &connection{ready: make(chan struct{}), users: 2, stopJobs: make(chan struct{}), jobsDone: make(chan struct{})}
+			close(used.ready)
+			go func() {
+				<-used.stopJobs
+				close(used.jobsDone)
+			}()
+			s.connections[c.connectionKey()] = used
+			So(s.Close(), ShouldBeNil)
+			So(s.connections, ShouldBeEmpty)
+
+			s.release(used)
+			select {
+			case <-used.jobsDone:
+				t.Error("connection closed while in use")
+			default:
+			}
+			s.release(used)
+			<-used.jobsDone
+			So(used.users, ShouldEqual, 0)
+		})
+	})
+}
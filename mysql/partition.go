@@ -0,0 +1,253 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// partitionNone keeps metrics tables unpartitioned
+	partitionNone = "none"
+	// partitionDay partitions metrics tables by day
+	partitionDay = "day"
+	// partitionMonth partitions metrics tables by month
+	partitionMonth = "month"
+
+	// partitionCheckInterval is how often partitions are maintained while publishing
+	partitionCheckInterval = time.Hour
+	// partitionBoundFormat formats the upper bounds of partitions as DATETIME literals
+	partitionBoundFormat = "2006-01-02 15:04:05"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// partitioning describes how metrics tables are partitioned by the timestamps of their rows
+type partitioning struct {
+	interval string
+	// ahead is the number of partitions kept ready after the current one
+	ahead int
+	// retention is how long partitions are kept after their last timestamp, zero keeps them forever
+	retention time.Duration
+	// loc is the time zone the driver converts timestamps to, partitions begin at midnight there
+	loc *time.Location
+}
+
+// existingPartition is a partition of an existing table, description holds its upper bound
+type existingPartition struct {
+	name        sql.NullString
+	description sql.NullString
+}
+
+func checkPartitioning(c *config) error {
+	switch c.partitioning {
+	case partitionNone:
+		return nil
+	case partitionDay, partitionMonth:
+	default:
+		return fmt.Errorf("Unknown partitioning '%s', supported values: %s, %s, %s", c.partitioning, partitionNone, partitionDay, partitionMonth)
+	}
+	if c.schema == schemaLegacy {
+		return fmt.Errorf("Invalid partitioning '%s', the %s schema stores timestamps as text, use %s or %s", c.partitioning, schemaLegacy, schemaTyped, schemaWide)
+	}
+	if c.partitionsAhead < 1 {
+		return fmt.Errorf("Invalid partitions_ahead %d, it must be positive", c.partitionsAhead)
+	}
+	return nil
+}
+
+// partitionedIndexes returns indexes with the timestamp added to the primary key,
+// MySQL requires every unique key of a partitioned table to include the partitioning column
+func partitionedIndexes(indexes []string) []string {
+	result := make([]string, len(indexes))
+	for i, index := range indexes {
+		if strings.HasPrefix(index, "PRIMARY KEY (") && !strings.Contains(index, "timestamp") {
+			index = strings.TrimSuffix(index, ")") + ", timestamp)"
+		}
+		result[i] = index
+	}
+	return result
+}
+
+// start returns the beginning of the partition holding rows of t
+func (p *partitioning) start(t time.Time) time.Time {
+	t = t.In(p.loc)
+	if p.interval == partitionMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, p.loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.loc)
+}
+
+// next returns the beginning of the partition following the one beginning at start
+func (p *partitioning) next(start time.Time) time.Time {
+	if p.interval == partitionMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// name returns the name of the partition beginning at start
+func (p *partitioning) name(start time.Time) string {
+	if p.interval == partitionMonth {
+		return start.Format("p200601")
+	}
+	return start.Format("p20060102")
+}
+
+// parseName returns the beginning of a partition named by the publisher, the second result is false for other partitions
+func (p *partitioning) parseName(name string) (time.Time, bool) {
+	format := "p20060102"
+	if p.interval == partitionMonth {
+		format = "p200601"
+	}
+	start, err := time.ParseInLocation(format, name, p.loc)
+	return start, err == nil
+}
+
+// definition returns the definition of the partition beginning at start, its bound is the wall time in loc
+// which is what the driver stores for timestamps of its rows
+func (p *partitioning) definition(start time.Time) string {
+	return "PARTITION " + p.name(start) + " VALUES LESS THAN ('" + p.next(start).Format(partitionBoundFormat) + "')"
+}
+
+// createClause returns the partitioning of a new table, with the current partition, the ones ahead of it and
+// a catch-all partition which keeps rows beyond them insertable
+func (p *partitioning) createClause(t time.Time) string {
+	var definitions []string
+	start := p.start(t)
+	for i := 0; i <= p.ahead; i++ {
+		definitions = append(definitions, p.definition(start))
+		start = p.next(start)
+	}
+	definitions = append(definitions, "PARTITION pfuture VALUES LESS THAN (MAXVALUE)")
+	return "PARTITION BY RANGE COLUMNS(timestamp) (" + strings.Join(definitions, ", ") + ")"
+}
+
+// maintenanceQueries returns the statements dropping partitions past the retention and adding the missing ones ahead of t
+func (p *partitioning) maintenanceQueries(table string, existing []existingPartition, t time.Time) ([]string, error) {
+	if len(existing) == 0 || !existing[0].name.Valid {
+		return nil, fmt.Errorf("Table %s is not partitioned, create it with the publisher or partition it by RANGE COLUMNS(timestamp)", table)
+	}
+
+	var drop []string
+	var last time.Time
+	catchAll := ""
+	for _, e := range existing {
+		if e.description.String == "MAXVALUE" {
+			catchAll = e.name.String
+			continue
+		}
+		start, ok := p.parseName(e.name.String)
+		if !ok {
+			continue
+		}
+		if p.next(start).After(last) {
+			last = p.next(start)
+		}
+		if p.retention > 0 && !p.next(start).After(t.Add(-p.retention)) {
+			drop = append(drop, e.name.String)
+		}
+	}
+
+	var add []string
+	current := p.start(t)
+	target := current
+	for i := 0; i <= p.ahead; i++ {
+		target = p.next(target)
+	}
+	start := last
+	if start.IsZero() {
+		start = current
+	}
+	for ; start.Before(target); start = p.next(start) {
+		add = append(add, p.definition(start))
+	}
+
+	var queries []string
+	if len(drop) > 0 {
+		queries = append(queries, "ALTER TABLE "+table+" DROP PARTITION "+strings.Join(drop, ", "))
+	}
+	if len(add) > 0 && catchAll != "" {
+		// rows beyond the last partition are moved from the catch-all partition to the new ones
+		add = append(add, "PARTITION "+catchAll+" VALUES LESS THAN (MAXVALUE)")
+		queries = append(queries, "ALTER TABLE "+table+" REORGANIZE PARTITION "+catchAll+" INTO ("+strings.Join(add, ", ")+")")
+	} else if len(add) > 0 {
+		queries = append(queries, "ALTER TABLE "+table+" ADD PARTITION ("+strings.Join(add, ", ")+")")
+	}
+	return queries, nil
+}
+
+// existingPartitions returns the partitions of table in order, a single one without name when the table is not partitioned
+func existingPartitions(db *sql.DB, database, table string) ([]existingPartition, error) {
+	rows, err := db.Query("SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS "+
+		"WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY PARTITION_ORDINAL_POSITION", database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var existing []existingPartition
+	for rows.Next() {
+		var e existingPartition
+		if err := rows.Scan(&e.name, &e.description); err != nil {
+			return nil, err
+		}
+		existing = append(existing, e)
+	}
+	return existing, rows.Err()
+}
+
+// maintainPartitions drops expired partitions of the destination tables and adds the ones ahead, it's done
+// when the connection is initialized and then by its goroutine at most once per partitionCheckInterval
+func (conn *connection) maintainPartitions() error {
+	if conn.partitioning == nil || now().Sub(conn.partitionsCheckedAt) < partitionCheckInterval {
+		return nil
+	}
+	logger := log.New()
+	for table, d := range conn.destinations {
+		existing, err := existingPartitions(conn.db, conn.database, table)
+		if err != nil {
+			logger.Printf("Error: cannot read partitions of table %v, err=%v", d.tableName, err)
+			return err
+		}
+		queries, err := conn.partitioning.maintenanceQueries(d.tableName, existing, now())
+		if err != nil {
+			logger.Printf("Error: %v", err)
+			return err
+		}
+		for _, query := range queries {
+			logger.Printf("Maintaining partitions of table %v: %v", d.tableName, query)
+			if err := conn.retry.do(func() error {
+				_, err := conn.db.Exec(query)
+				return err
+			}); err != nil {
+				logger.Printf("Error: cannot maintain partitions of table %v, err=%v", d.tableName, err)
+				return err
+			}
+		}
+	}
+	conn.partitionsCheckedAt = now()
+	return nil
+}
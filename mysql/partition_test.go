@@ -0,0 +1,138 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testPartitions(names ...string) []existingPartition {
+	var existing []existingPartition
+	for _, name := range names {
+		description := "'bound'"
+		if name == "pfuture" {
+			description = "MAXVALUE"
+		}
+		existing = append(existing, existingPartition{name: sql.NullString{String: name, Valid: true}, description: sql.NullString{String: description, Valid: true}})
+	}
+	return existing
+}
+
+func TestPartitioning(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 12, 30, 0, 0, time.UTC)
+
+	Convey("Daily partitions", t, func() {
+		p := &partitioning{interval: partitionDay, ahead: 2, retention: 3 * 24 * time.Hour, loc: time.UTC}
+
+		Convey("So partitions should be named after their first day", func() {
+			So(p.name(p.start(ts)), ShouldEqual, "p20161028")
+			So(p.next(p.start(ts)), ShouldResemble, time.Date(2016, 10, 29, 0, 0, 0, 0, time.UTC))
+			start, ok := p.parseName("p20161028")
+			So(ok, ShouldBeTrue)
+			So(start, ShouldResemble, p.start(ts))
+			_, ok = p.parseName("pfuture")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("So a new table should have the current partition, the ones ahead and a catch-all one", func() {
+			So(p.createClause(ts), ShouldEqual, "PARTITION BY RANGE COLUMNS(timestamp) ("+
+				"PARTITION p20161028 VALUES LESS THAN ('2016-10-29 00:00:00'), "+
+				"PARTITION p20161029 VALUES LESS THAN ('2016-10-30 00:00:00'), "+
+				"PARTITION p20161030 VALUES LESS THAN ('2016-10-31 00:00:00'), "+
+				"PARTITION pfuture VALUES LESS THAN (MAXVALUE))")
+		})
+		Convey("So an up to date table should need no maintenance", func() {
+			queries, err := p.maintenanceQueries("info", testPartitions("p20161026", "p20161027", "p20161028", "p20161029", "p20161030", "pfuture"), ts)
+			So(err, ShouldBeNil)
+			So(queries, ShouldBeEmpty)
+		})
+		Convey("So expired partitions should be dropped and missing ones split off the catch-all one", func() {
+			queries, err := p.maintenanceQueries("info", testPartitions("p20161023", "p20161024", "p20161025", "p20161026", "p20161027", "pfuture"), ts)
+			So(err, ShouldBeNil)
+			So(queries, ShouldResemble, []string{
+				"ALTER TABLE info DROP PARTITION p20161023, p20161024",
+				"ALTER TABLE info REORGANIZE PARTITION pfuture INTO (" +
+					"PARTITION p20161028 VALUES LESS THAN ('2016-10-29 00:00:00'), " +
+					"PARTITION p20161029 VALUES LESS THAN ('2016-10-30 00:00:00'), " +
+					"PARTITION p20161030 VALUES LESS THAN ('2016-10-31 00:00:00'), " +
+					"PARTITION pfuture VALUES LESS THAN (MAXVALUE))",
+			})
+		})
+		Convey("So partitions should be added to a table without a catch-all partition", func() {
+			queries, err := p.maintenanceQueries("info", testPartitions("p20161028", "p20161029"), ts)
+			So(err, ShouldBeNil)
+			So(queries, ShouldResemble, []string{"ALTER TABLE info ADD PARTITION (PARTITION p20161030 VALUES LESS THAN ('2016-10-31 00:00:00'))"})
+		})
+		Convey("So partitions should be kept forever without retention", func() {
+			p.retention = 0
+			queries, err := p.maintenanceQueries("info", testPartitions("p20150101", "p20161028", "p20161029", "p20161030", "pfuture"), ts)
+			So(err, ShouldBeNil)
+			So(queries, ShouldBeEmpty)
+		})
+		Convey("So a table which is not partitioned should return an error", func() {
+			_, err := p.maintenanceQueries("info", []existingPartition{{}}, ts)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Partitions in the time zone of the connection", t, func() {
+		loc := time.FixedZone("UTC+10", 10*60*60)
+		p := &partitioning{interval: partitionDay, ahead: 1, retention: 2 * 24 * time.Hour, loc: loc}
+		late := time.Date(2016, 10, 28, 20, 30, 0, 0, time.UTC)
+
+		Convey("So rows should go to the partition of their day in loc", func() {
+			So(p.start(late), ShouldResemble, time.Date(2016, 10, 29, 0, 0, 0, 0, loc))
+			start, ok := p.parseName("p20161029")
+			So(ok, ShouldBeTrue)
+			So(start, ShouldResemble, p.start(late))
+		})
+		Convey("So bounds should be the wall times stored by the driver", func() {
+			So(p.createClause(late), ShouldEqual, "PARTITION BY RANGE COLUMNS(timestamp) ("+
+				"PARTITION p20161029 VALUES LESS THAN ('2016-10-30 00:00:00'), "+
+				"PARTITION p20161030 VALUES LESS THAN ('2016-10-31 00:00:00'), "+
+				"PARTITION pfuture VALUES LESS THAN (MAXVALUE))")
+		})
+		Convey("So partitions should expire once their last row in loc is past the retention", func() {
+			queries, err := p.maintenanceQueries("info", testPartitions("p20161026", "p20161027", "p20161029", "p20161030", "pfuture"), late)
+			So(err, ShouldBeNil)
+			So(queries, ShouldResemble, []string{"ALTER TABLE info DROP PARTITION p20161026"})
+		})
+	})
+
+	Convey("Monthly partitions", t, func() {
+		p := &partitioning{interval: partitionMonth, ahead: 1, loc: time.UTC}
+		So(p.createClause(ts), ShouldEqual, "PARTITION BY RANGE COLUMNS(timestamp) ("+
+			"PARTITION p201610 VALUES LESS THAN ('2016-11-01 00:00:00'), "+
+			"PARTITION p201611 VALUES LESS THAN ('2016-12-01 00:00:00'), "+
+			"PARTITION pfuture VALUES LESS THAN (MAXVALUE))")
+	})
+
+	Convey("Primary keys of partitioned tables should include the timestamp", t, func() {
+		typed, _ := newLayout(schemaTyped, tagsNone)
+		So(partitionedIndexes(typed.indexes)[0], ShouldEqual, "PRIMARY KEY (id, timestamp)")
+		wide, _ := newLayout(schemaWide, tagsNone)
+		So(partitionedIndexes(wide.indexes), ShouldResemble, wide.indexes)
+	})
+}
@@ -0,0 +1,119 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// MySQL server error numbers of transient errors
+const (
+	errTooManyConnections = 1040
+	errServerShutdown     = 1053
+	errLockWaitTimeout    = 1205
+	errDeadlock           = 1213
+	errServerGone         = 2006
+	errServerLost         = 2013
+)
+
+// sleep is replaced in tests
+var sleep = time.Sleep
+
+// retryPolicy describes how many times and how often a failed operation is retried
+type retryPolicy struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// do calls fn until it succeeds, fails with an error which is not retryable or the retries are exhausted,
+// the delay between attempts doubles up to maxBackoff
+func (p retryPolicy) do(fn func() error) error {
+	delay := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > p.retries || !retryable(err) {
+			return err
+		}
+		log.New().Printf("Retrying in %v (attempt %d of %d), err=%v", delay, attempt, p.retries, err)
+		sleep(delay)
+		delay *= 2
+		if delay > p.maxBackoff {
+			delay = p.maxBackoff
+		}
+	}
+}
+
+// retryable reports whether err is transient, so the failed operation may succeed when retried
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *rollbackError:
+		return retryable(e.err)
+	case *writersError:
+		for _, err := range e.errs {
+			if !retryable(err) {
+				return false
+			}
+		}
+		return true
+	case *mysqldriver.MySQLError:
+		switch e.Number {
+		case errTooManyConnections, errServerShutdown, errLockWaitTimeout, errDeadlock, errServerGone, errServerLost:
+			return true
+		}
+		return false
+	case net.Error:
+		// the server is not reachable, e.g. it's restarting
+		return true
+	}
+	return err == driver.ErrBadConn || err == mysqldriver.ErrInvalidConn
+}
+
+// retryExecer retries statements and queries which fail with a transient error
+type retryExecer struct {
+	queryer
+	policy retryPolicy
+}
+
+func (r retryExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := r.policy.do(func() error {
+		var err error
+		res, err = r.queryer.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+func (r retryExecer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := r.policy.do(func() error {
+		var err error
+		rows, err = r.queryer.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
@@ -0,0 +1,107 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryable(t *testing.T) {
+	Convey("Classify MySQL errors", t, func() {
+		Convey("So broken connections should be retryable", func() {
+			So(retryable(driver.ErrBadConn), ShouldBeTrue)
+			So(retryable(mysqldriver.ErrInvalidConn), ShouldBeTrue)
+			So(retryable(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), ShouldBeTrue)
+		})
+		Convey("So deadlocks, lock wait timeouts and lost servers should be retryable", func() {
+			for _, n := range []uint16{1205, 1213, 2006, 2013} {
+				So(retryable(&mysqldriver.MySQLError{Number: n}), ShouldBeTrue)
+			}
+		})
+		Convey("So rolled back transactions should be classified by their cause", func() {
+			So(retryable(&rollbackError{attempted: 1, err: &mysqldriver.MySQLError{Number: 1213}}), ShouldBeTrue)
+			So(retryable(&rollbackError{attempted: 1, err: &mysqldriver.MySQLError{Number: 1146}}), ShouldBeFalse)
+		})
+		Convey("So other errors should be fatal", func() {
+			So(retryable(&mysqldriver.MySQLError{Number: 1045, Message: "Access denied"}), ShouldBeFalse)
+			So(retryable(&mysqldriver.MySQLError{Number: 1146, Message: "Table doesn't exist"}), ShouldBeFalse)
+			So(retryable(errors.New("Unknown content type")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	policy := retryPolicy{retries: 4, backoff: 100 * time.Millisecond, maxBackoff: 300 * time.Millisecond}
+
+	Convey("Retry failed operations", t, func() {
+		delays = nil
+		calls := 0
+
+		Convey("So a transient error should be retried with exponential backoff", func() {
+			err := policy.do(func() error {
+				calls++
+				return driver.ErrBadConn
+			})
+			So(err, ShouldEqual, driver.ErrBadConn)
+			So(calls, ShouldEqual, 5)
+			So(delays, ShouldResemble, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond})
+		})
+		Convey("So an operation should stop being retried once it succeeds", func() {
+			err := policy.do(func() error {
+				calls++
+				if calls < 3 {
+					return &mysqldriver.MySQLError{Number: 1213}
+				}
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 3)
+		})
+		Convey("So a fatal error should not be retried", func() {
+			err := policy.do(func() error {
+				calls++
+				return &mysqldriver.MySQLError{Number: 1045}
+			})
+			So(err, ShouldNotBeNil)
+			So(calls, ShouldEqual, 1)
+			So(delays, ShouldBeEmpty)
+		})
+		Convey("So no retries should call the operation once", func() {
+			retryPolicy{}.do(func() error {
+				calls++
+				return driver.ErrBadConn
+			})
+			So(calls, ShouldEqual, 1)
+		})
+	})
+}
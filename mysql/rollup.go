@@ -0,0 +1,255 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// rollupTableSuffix followed by the interval is appended to the metrics table name to get the name of a rollup table
+	rollupTableSuffix = "_rollup_"
+	// rollupGrace delays rolling up a bucket, so that metrics published late are included
+	rollupGrace = time.Minute
+	// rollupMaxBuckets limits the buckets rolled up in one run, so that catching up doesn't hold the table for long,
+	// the following ones are rolled up by the next runs
+	rollupMaxBuckets = 1000
+
+	// purgeInterval is how often expired rows are purged
+	purgeInterval = time.Hour
+	// purgeBatchSize is the number of rows deleted with one statement, so that purging never locks the table for long
+	purgeBatchSize = 10000
+	// purgeMaxBatches limits the batches deleted in one run, the remaining expired rows are purged by the next runs
+	purgeMaxBatches = 10
+
+	// datetimeFormat parses DATETIME values read without parseTime, fractional seconds are optional
+	datetimeFormat = "2006-01-02 15:04:05"
+)
+
+// rollupEpoch aligns the buckets of rollups
+var rollupEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// rollupTableColumns are the columns of a rollup table written by the publisher
+var rollupTableColumns = []column{{name: "bucket", definition: "DATETIME"}, {name: "source_column", definition: "VARCHAR"},
+	{name: "key_column", definition: "VARCHAR"}, {name: "value_min", definition: "DOUBLE"}, {name: "value_max", definition: "DOUBLE"},
+	{name: "value_avg", definition: "DOUBLE"}, {name: "value_count", definition: "BIGINT"}}
+
+// parseRollups parses comma separated intervals of rollups, Go durations or whole days like "1d"
+func parseRollups(s string) ([]time.Duration, error) {
+	var rollups []time.Duration
+	added := map[time.Duration]bool{}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		var interval time.Duration
+		if strings.HasSuffix(r, "d") {
+			days, err := strconv.Atoi(strings.TrimSuffix(r, "d"))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rollup interval '%s': %v", r, err)
+			}
+			interval = time.Duration(days) * 24 * time.Hour
+		} else {
+			var err error
+			if interval, err = time.ParseDuration(r); err != nil {
+				return nil, fmt.Errorf("Invalid rollup interval '%s': %v", r, err)
+			}
+		}
+		if interval < time.Second || interval%time.Second != 0 {
+			return nil, fmt.Errorf("Invalid rollup interval '%s', it must be a positive number of seconds", r)
+		}
+		if !added[interval] {
+			added[interval] = true
+			rollups = append(rollups, interval)
+		}
+	}
+	return rollups, nil
+}
+
+// rollupSuffix returns the suffix of the rollup table of interval, like "_rollup_1m"
+func rollupSuffix(interval time.Duration) string {
+	switch {
+	case interval%(24*time.Hour) == 0:
+		return fmt.Sprintf("%s%dd", rollupTableSuffix, interval/(24*time.Hour))
+	case interval%time.Hour == 0:
+		return fmt.Sprintf("%s%dh", rollupTableSuffix, interval/time.Hour)
+	case interval%time.Minute == 0:
+		return fmt.Sprintf("%s%dm", rollupTableSuffix, interval/time.Minute)
+	}
+	return fmt.Sprintf("%s%ds", rollupTableSuffix, interval/time.Second)
+}
+
+// bucketStart returns the beginning of the bucket of interval holding t, buckets are aligned on the wall clock of
+// the location of t like the DATETIME arithmetic of rollupQuery
+func bucketStart(t time.Time, interval time.Duration) time.Time {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	b := rollupEpoch.Add(wall.Sub(rollupEpoch) / interval * interval)
+	return time.Date(b.Year(), b.Month(), b.Day(), b.Hour(), b.Minute(), b.Second(), b.Nanosecond(), t.Location())
+}
+
+// rollupWindow returns the buckets of interval which are complete at t and not rolled up yet, last is the latest
+// rolled up bucket and next the earliest stored numeric value after it, zero when there are none; the window starts
+// with the bucket of next, so that gaps without values are skipped. The second result is false when there's nothing
+// to roll up
+func rollupWindow(last, next, t time.Time, interval time.Duration) (time.Time, time.Time, bool) {
+	if next.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	from := bucketStart(next, interval)
+	if !last.IsZero() && from.Before(last.Add(interval)) {
+		from = last.Add(interval)
+	}
+	to := bucketStart(t.Add(-rollupGrace), interval)
+	if limit := from.Add(rollupMaxBuckets * interval); to.After(limit) {
+		to = limit
+	}
+	return from, to, from.Before(to)
+}
+
+// rollupTableQuery returns the statement creating a rollup table if it's not already there
+func rollupTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (bucket DATETIME NOT NULL, source_column VARCHAR(255) NOT NULL, key_column VARCHAR(255) NOT NULL, " +
+		"value_min DOUBLE NOT NULL, value_max DOUBLE NOT NULL, value_avg DOUBLE NOT NULL, value_count BIGINT NOT NULL, " +
+		"PRIMARY KEY (key_column, bucket, source_column), INDEX bucket (bucket))"
+}
+
+// rollupQuery returns the statement aggregating numeric values of the metrics table within a time range by buckets of interval,
+// buckets rolled up again are replaced
+func rollupQuery(rollupTable, table string, interval time.Duration) string {
+	seconds := strconv.FormatInt(int64(interval/time.Second), 10)
+	bucket := "DATE_ADD('" + rollupEpoch.Format(datetimeFormat) + "', INTERVAL FLOOR(TIMESTAMPDIFF(SECOND, '" + rollupEpoch.Format(datetimeFormat) +
+		"', timestamp) / " + seconds + ") * " + seconds + " SECOND)"
+	return "INSERT INTO" + " " + rollupTable + " (bucket, source_column, key_column, value_min, value_max, value_avg, value_count) " +
+		"SELECT " + bucket + ", source_column, key_column, MIN(value_numeric), MAX(value_numeric), AVG(value_numeric), COUNT(value_numeric) " +
+		"FROM " + table + " WHERE timestamp >= ? AND timestamp < ? AND value_numeric IS NOT NULL GROUP BY 1, source_column, key_column " +
+		"ON DUPLICATE KEY UPDATE value_min = VALUES(value_min), value_max = VALUES(value_max), value_avg = VALUES(value_avg), value_count = VALUES(value_count)"
+}
+
+// queryTime returns the DATETIME selected by query, zero when it's NULL; it's read in loc, the time zone the driver
+// converts timestamps to
+func queryTime(db *sql.DB, loc *time.Location, query string, args ...interface{}) (time.Time, error) {
+	var s sql.NullString
+	if err := db.QueryRow(query, args...).Scan(&s); err != nil || !s.Valid {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(datetimeFormat, s.String, loc)
+}
+
+// rollup aggregates the complete buckets of interval of table d which are not rolled up yet, up to rollupMaxBuckets;
+// the first result reports whether complete buckets are left for the next run
+func (conn *connection) rollup(table string, d *destination, interval time.Duration) (bool, error) {
+	rollupTable := qualifiedName(conn.database, table+rollupSuffix(interval))
+	last, err := queryTime(conn.db, conn.loc, "SELECT MAX(bucket) FROM "+rollupTable)
+	if err != nil {
+		return false, err
+	}
+	// rows without numeric values are not rolled up, a window of only those would be retried forever
+	var next time.Time
+	if last.IsZero() {
+		next, err = queryTime(conn.db, conn.loc, "SELECT MIN(timestamp) FROM "+d.tableName+" WHERE value_numeric IS NOT NULL")
+	} else {
+		next, err = queryTime(conn.db, conn.loc, "SELECT MIN(timestamp) FROM "+d.tableName+" WHERE timestamp >= ? AND value_numeric IS NOT NULL", last.Add(interval))
+	}
+	if err != nil {
+		return false, err
+	}
+	from, to, ok := rollupWindow(last, next, now().In(conn.loc), interval)
+	if !ok {
+		return false, nil
+	}
+	err = conn.retry.do(func() error {
+		_, err := conn.db.Exec(rollupQuery(rollupTable, d.tableName, interval), from, to)
+		return err
+	})
+	return err == nil && !to.Before(from.Add(rollupMaxBuckets*interval)), err
+}
+
+// purge deletes the rows of table d older than the retention, in at most purgeMaxBatches batches; the second result
+// reports whether expired rows are left for the next run
+func (conn *connection) purge(d *destination) (int64, bool, error) {
+	before := now().Add(-conn.retention)
+	var purged int64
+	for batch := 0; batch < purgeMaxBatches; batch++ {
+		var res sql.Result
+		err := conn.retry.do(func() error {
+			var err error
+			res, err = conn.db.Exec("DELETE FROM "+d.tableName+" WHERE timestamp < ? LIMIT "+strconv.Itoa(purgeBatchSize), before)
+			return err
+		})
+		if err != nil {
+			return purged, false, err
+		}
+		n, _ := res.RowsAffected()
+		purged += n
+		if n < purgeBatchSize {
+			return purged, false, nil
+		}
+	}
+	return purged, true, nil
+}
+
+// runJobs rolls up the destination tables when a bucket of a rollup is complete and purges their expired rows
+// at most once per purgeInterval, they're run by the goroutine of the connection; failed and unfinished jobs
+// are run again on its next tick
+func (conn *connection) runJobs() {
+	logger := log.New()
+	for _, interval := range conn.rollups {
+		if now().Sub(conn.rolledUpAt[interval]) < interval {
+			continue
+		}
+		pending := false
+		for table, d := range conn.destinations {
+			more, err := conn.rollup(table, d, interval)
+			if err != nil {
+				logger.Printf("Error: cannot roll up table %v by %v, err=%v", d.tableName, interval, err)
+			}
+			pending = pending || more || err != nil
+		}
+		if !pending {
+			conn.rolledUpAt[interval] = now()
+		}
+	}
+
+	// partitioned tables drop expired partitions instead
+	if conn.retention == 0 || conn.partitioning != nil || now().Sub(conn.purgedAt) < purgeInterval {
+		return
+	}
+	pending := false
+	for _, d := range conn.destinations {
+		purged, more, err := conn.purge(d)
+		if err != nil {
+			logger.Printf("Error: cannot purge expired rows of table %v, err=%v", d.tableName, err)
+		}
+		if purged > 0 {
+			logger.Printf("Purged %d rows older than %v from table %v", purged, conn.retention, d.tableName)
+		}
+		pending = pending || more || err != nil
+	}
+	if !pending {
+		conn.purgedAt = now()
+	}
+}
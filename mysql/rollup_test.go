@@ -0,0 +1,125 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRollups(t *testing.T) {
+	Convey("Rollups of numeric values", t, func() {
+		Convey("So intervals should be parsed in order without duplicates", func() {
+			rollups, err := parseRollups("1m, 1h,60s,1d")
+			So(err, ShouldBeNil)
+			So(rollups, ShouldResemble, []time.Duration{time.Minute, time.Hour, 24 * time.Hour})
+			rollups, err = parseRollups("")
+			So(err, ShouldBeNil)
+			So(rollups, ShouldBeEmpty)
+		})
+		Convey("So invalid intervals should return an error", func() {
+			for _, r := range []string{"1x", "500ms", "1.5s", "0s", "-1m", "xd"} {
+				_, err := parseRollups(r)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("So rollup tables should be named after their intervals", func() {
+			So(rollupSuffix(30*time.Second), ShouldEqual, "_rollup_30s")
+			So(rollupSuffix(5*time.Minute), ShouldEqual, "_rollup_5m")
+			So(rollupSuffix(time.Hour), ShouldEqual, "_rollup_1h")
+			So(rollupSuffix(90*time.Minute), ShouldEqual, "_rollup_90m")
+			So(rollupSuffix(48*time.Hour), ShouldEqual, "_rollup_2d")
+		})
+		Convey("So buckets should be aligned to their intervals", func() {
+			ts := time.Date(2016, 10, 28, 12, 34, 56, 789, time.UTC)
+			So(bucketStart(ts, time.Minute), ShouldResemble, time.Date(2016, 10, 28, 12, 34, 0, 0, time.UTC))
+			So(bucketStart(ts, time.Hour), ShouldResemble, time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC))
+			So(bucketStart(ts, 15*time.Minute), ShouldResemble, time.Date(2016, 10, 28, 12, 30, 0, 0, time.UTC))
+			cet := time.FixedZone("CET", 3600)
+			So(bucketStart(ts.In(cet), 24*time.Hour), ShouldResemble, time.Date(2016, 10, 28, 0, 0, 0, 0, cet))
+		})
+		Convey("So only complete buckets which are not rolled up yet should be rolled up", func() {
+			ts := time.Date(2016, 10, 28, 12, 34, 56, 0, time.UTC)
+			from, to, ok := rollupWindow(time.Time{}, time.Date(2016, 10, 28, 12, 30, 10, 0, time.UTC), ts, time.Minute)
+			So(ok, ShouldBeTrue)
+			So(from, ShouldResemble, time.Date(2016, 10, 28, 12, 30, 0, 0, time.UTC))
+			So(to, ShouldResemble, time.Date(2016, 10, 28, 12, 33, 0, 0, time.UTC))
+
+			from, to, ok = rollupWindow(time.Date(2016, 10, 28, 12, 31, 0, 0, time.UTC), time.Date(2016, 10, 28, 12, 32, 10, 0, time.UTC), ts, time.Minute)
+			So(ok, ShouldBeTrue)
+			So(from, ShouldResemble, time.Date(2016, 10, 28, 12, 32, 0, 0, time.UTC))
+			So(to, ShouldResemble, time.Date(2016, 10, 28, 12, 33, 0, 0, time.UTC))
+
+			_, _, ok = rollupWindow(time.Date(2016, 10, 28, 12, 32, 0, 0, time.UTC), time.Date(2016, 10, 28, 12, 33, 5, 0, time.UTC), ts, time.Minute)
+			So(ok, ShouldBeFalse)
+			_, _, ok = rollupWindow(time.Date(2016, 10, 28, 12, 32, 0, 0, time.UTC), time.Time{}, ts, time.Minute)
+			So(ok, ShouldBeFalse)
+			_, _, ok = rollupWindow(time.Time{}, time.Time{}, ts, time.Minute)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("So gaps longer than the catch up limit should be skipped", func() {
+			ts := time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC)
+			last := ts.AddDate(0, 0, -7)
+			next := ts.Add(-10*time.Minute + 30*time.Second)
+			from, to, ok := rollupWindow(last, next, ts, time.Minute)
+			So(ok, ShouldBeTrue)
+			So(from, ShouldResemble, time.Date(2016, 10, 28, 11, 50, 0, 0, time.UTC))
+			So(to, ShouldResemble, time.Date(2016, 10, 28, 11, 59, 0, 0, time.UTC))
+		})
+		Convey("So catching up should be limited", func() {
+			ts := time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC)
+			from, to, ok := rollupWindow(time.Time{}, ts.AddDate(-1, 0, 0), ts, time.Minute)
+			So(ok, ShouldBeTrue)
+			So(to.Sub(from), ShouldEqual, rollupMaxBuckets*time.Minute)
+		})
+		Convey("So values should be aggregated by bucket, source and namespace", func() {
+			q := rollupQuery("`snap`.`info_rollup_1m`", "`snap`.`info`", time.Minute)
+			So(q, ShouldStartWith, "INSERT INTO `snap`.`info_rollup_1m` (bucket, source_column, key_column, value_min, value_max, value_avg, value_count) "+
+				"SELECT DATE_ADD('2000-01-01 00:00:00', INTERVAL FLOOR(TIMESTAMPDIFF(SECOND, '2000-01-01 00:00:00', timestamp) / 60) * 60 SECOND), ")
+			So(q, ShouldContainSubstring, "FROM `snap`.`info` WHERE timestamp >= ? AND timestamp < ? AND value_numeric IS NOT NULL GROUP BY 1, source_column, key_column")
+			So(q, ShouldContainSubstring, "ON DUPLICATE KEY UPDATE value_min = VALUES(value_min)")
+		})
+	})
+
+	Convey("Run jobs in the background", t, func() {
+		Convey("So jobs should run without a publish and stop when the connection is closed", func() {
+			conn := &connection{rollups: []time.Duration{time.Minute}, rolledUpAt: map[time.Duration]time.Time{}}
+			conn.startJobs()
+			So(conn.stopJobs, ShouldNotBeNil)
+			conn.close()
+			So(conn.rolledUpAt, ShouldContainKey, time.Minute)
+			select {
+			case <-conn.jobsDone:
+			default:
+				t.Error("jobs still running after the connection was closed")
+			}
+		})
+		Convey("So a connection without jobs should not start a goroutine", func() {
+			conn := &connection{}
+			conn.startJobs()
+			So(conn.stopJobs, ShouldBeNil)
+			conn.close()
+		})
+	})
+}
@@ -0,0 +1,83 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// route sends metrics whose namespace matches pattern to table
+type route struct {
+	// pattern holds glob patterns matched against the leading elements of a namespace
+	pattern []string
+	table   string
+}
+
+// parseRoutes reads routes written as comma separated pattern=table pairs, like /intel/procfs/*=procfs_metrics
+func parseRoutes(routes string) ([]route, error) {
+	var parsed []route
+	for _, r := range strings.Split(routes, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		i := strings.LastIndex(r, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid route '%s', it must be written as pattern=table", r)
+		}
+		pattern, table := strings.TrimSpace(r[:i]), strings.TrimSpace(r[i+1:])
+		if !strings.HasPrefix(pattern, "/") || len(pattern) < 2 {
+			return nil, fmt.Errorf("Invalid route '%s', the pattern must be a namespace starting with /", r)
+		}
+		elements := strings.Split(pattern[1:], "/")
+		for _, e := range elements {
+			if _, err := path.Match(e, ""); e == "" || err != nil {
+				return nil, fmt.Errorf("Invalid route '%s', the pattern has an invalid element '%s'", r, e)
+			}
+		}
+		parsed = append(parsed, route{pattern: elements, table: table})
+	}
+	return parsed, nil
+}
+
+// match reports whether the leading elements of namespace match the pattern of r
+func (r *route) match(namespace []string) bool {
+	if len(namespace) < len(r.pattern) {
+		return false
+	}
+	for i, p := range r.pattern {
+		if ok, _ := path.Match(p, namespace[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRoute returns the first of routes matching namespace, nil when none does
+func matchRoute(routes []route, namespace []string) *route {
+	for i := range routes {
+		if routes[i].match(namespace) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
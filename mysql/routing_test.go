@@ -0,0 +1,84 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRoutes(t *testing.T) {
+	Convey("Route metrics to tables by their namespaces", t, func() {
+		Convey("So routes should be parsed in order", func() {
+			routes, err := parseRoutes("/intel/procfs/*=procfs_metrics, /intel/mock/foo = mock_foo,")
+			So(err, ShouldBeNil)
+			So(routes, ShouldResemble, []route{
+				{pattern: []string{"intel", "procfs", "*"}, table: "procfs_metrics"},
+				{pattern: []string{"intel", "mock", "foo"}, table: "mock_foo"},
+			})
+			routes, err = parseRoutes("")
+			So(err, ShouldBeNil)
+			So(routes, ShouldBeEmpty)
+		})
+		Convey("So invalid routes should return an error", func() {
+			for _, r := range []string{"/intel/procfs", "intel/procfs=procfs", "/=procfs", "/intel//procfs=procfs", "/intel/[procfs=procfs"} {
+				_, err := parseRoutes(r)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("So patterns should match the leading elements of namespaces", func() {
+			routes, _ := parseRoutes("/intel/procfs/*=procfs_metrics,/intel/*/cpu*=cpu_metrics,/intel=intel_metrics")
+			So(matchRoute(routes, []string{"intel", "procfs", "meminfo", "free"}).table, ShouldEqual, "procfs_metrics")
+			So(matchRoute(routes, []string{"intel", "procfs"}).table, ShouldEqual, "intel_metrics")
+			So(matchRoute(routes, []string{"intel", "linux", "cpu0", "user"}).table, ShouldEqual, "cpu_metrics")
+			So(matchRoute(routes, []string{"intel", "mock", "foo"}).table, ShouldEqual, "intel_metrics")
+			So(matchRoute(routes, []string{"staples", "mock", "foo"}), ShouldBeNil)
+		})
+		Convey("So rows should be grouped by destination in order of their first rows", func() {
+			routes, _ := parseRoutes("/intel/procfs=procfs_metrics")
+			conn := &connection{
+				defaultTable: "info",
+				routes:       routes,
+				destinations: map[string]*destination{"info": {tableName: "`snap`.`info`"}, "procfs_metrics": {tableName: "`snap`.`procfs_metrics`"}},
+			}
+			var rows []row
+			var metrics []plugin.MetricType
+			for _, ns := range [][]string{{"intel", "mock", "foo"}, {"intel", "procfs", "load"}, {"intel", "mock", "bar"}} {
+				m := *plugin.NewMetricType(core.NewNamespace(ns...), time.Now(), nil, "", 1)
+				metrics = append(metrics, m)
+				rows = append(rows, row{key: sliceToString(ns), namespace: m.Namespace()})
+			}
+
+			groups := conn.route(rows, metrics)
+			So(groups, ShouldHaveLength, 2)
+			So(groups[0].dest.tableName, ShouldEqual, "`snap`.`info`")
+			So(groups[0].rows, ShouldHaveLength, 2)
+			So(groups[0].metrics[1].Namespace().Strings(), ShouldResemble, []string{"intel", "mock", "bar"})
+			So(groups[1].dest.tableName, ShouldEqual, "`snap`.`procfs_metrics`")
+			So(groups[1].rows[0].namespace.Strings(), ShouldResemble, []string{"intel", "procfs", "load"})
+		})
+	})
+}
@@ -0,0 +1,200 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap/core"
+)
+
+const (
+	// schemaLegacy stores every field of a metric as VARCHAR(200)
+	schemaLegacy = "legacy"
+	// schemaTyped stores typed values with a primary key and indexes for time-range queries
+	schemaTyped = "typed"
+
+	// maxPlaceholders is the limit of placeholders in a MySQL prepared statement
+	maxPlaceholders = 65535
+)
+
+// row holds a metric converted for insertion, data keeps the value of the metric before conversion to string
+type row struct {
+	timestamp time.Time
+	source    string
+	key       string
+	value     string
+	data      interface{}
+	tags      map[string]string
+	// tagsID identifies the set of tags in the tags tables, it's assigned before the row is written
+	tagsID int64
+
+	namespace   core.Namespace
+	unit        string
+	description string
+}
+
+// column describes a column of the metrics table
+type column struct {
+	name       string
+	definition string
+	// value returns the value inserted for a row, columns without it are filled by MySQL
+	value func(r *row) interface{}
+}
+
+// layout describes the columns and indexes of the metrics table
+type layout struct {
+	columns []column
+	// indexes holds the key definitions following the columns in CREATE TABLE
+	indexes []string
+	// onDuplicate handles metrics written again when the table has the unique key, it's empty otherwise
+	onDuplicate string
+}
+
+// newLayout returns the layout of the given schema, extended with the columns storing tags
+func newLayout(schema, tags string) (*layout, error) {
+	l, err := schemaLayout(schema)
+	if err != nil {
+		return nil, err
+	}
+	columns, indexes, err := tagsColumns(tags)
+	if err != nil {
+		return nil, err
+	}
+	l.columns = append(l.columns, columns...)
+	l.indexes = append(l.indexes, indexes...)
+	return l, nil
+}
+
+func schemaLayout(schema string) (*layout, error) {
+	switch schema {
+	case schemaLegacy:
+		return &layout{
+			columns: []column{
+				{"timestamp", "VARCHAR(200)", func(r *row) interface{} { return r.timestamp }},
+				{"source_column", "VARCHAR(200)", func(r *row) interface{} { return r.source }},
+				{"key_column", "VARCHAR(200)", func(r *row) interface{} { return r.key }},
+				{"value_column", "VARCHAR(200)", func(r *row) interface{} { return r.value }},
+			},
+		}, nil
+	case schemaTyped:
+		return &layout{
+			columns: []column{
+				{"id", "BIGINT UNSIGNED NOT NULL AUTO_INCREMENT", nil},
+				{"timestamp", "DATETIME(6) NOT NULL", func(r *row) interface{} { return r.timestamp }},
+				{"source_column", "VARCHAR(255) NOT NULL", func(r *row) interface{} { return r.source }},
+				{"key_column", "VARCHAR(255) NOT NULL", func(r *row) interface{} { return r.key }},
+				{"value_numeric", "DOUBLE NULL", numericValue},
+				{"value_column", "TEXT NULL", textValue},
+			},
+			indexes: []string{
+				"PRIMARY KEY (id)",
+				"INDEX namespace_timestamp (key_column, timestamp)",
+				"INDEX source_timestamp (source_column, timestamp)",
+				"INDEX timestamp (timestamp)",
+			},
+		}, nil
+	case schemaWide:
+		// the columns of namespaces are added as they appear
+		return &layout{
+			columns: []column{
+				{"timestamp", "DATETIME(6) NOT NULL", func(r *row) interface{} { return r.timestamp }},
+				{"source_column", "VARCHAR(255) NOT NULL", func(r *row) interface{} { return r.source }},
+			},
+			indexes: []string{"PRIMARY KEY (timestamp, source_column)"},
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown schema '%s', supported schemas: %s, %s, %s", schema, schemaLegacy, schemaTyped, schemaWide)
+}
+
+// insertColumns returns the columns filled by the publisher
+func (l *layout) insertColumns() []column {
+	var columns []column
+	for _, c := range l.columns {
+		if c.value != nil {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// maxBatchSize returns the maximum number of rows inserted with one statement
+func (l *layout) maxBatchSize() int {
+	return maxPlaceholders / len(l.insertColumns())
+}
+
+// createQuery returns the statement creating the table if it's not already there
+func (l *layout) createQuery(table string) string {
+	definitions := make([]string, 0, len(l.columns)+len(l.indexes))
+	for _, c := range l.columns {
+		definitions = append(definitions, c.name+" "+c.definition)
+	}
+	definitions = append(definitions, l.indexes...)
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (" + strings.Join(definitions, ", ") + ")"
+}
+
+// insertQuery returns a multi-row INSERT statement for the given number of rows, handling duplicates according to onDuplicate
+func (l *layout) insertQuery(table string, rows int) string {
+	columns := l.insertColumns()
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	clause := ""
+	switch l.onDuplicate {
+	case duplicateIgnore:
+		clause = ignoreClause
+	case duplicateUpdate:
+		clause = l.duplicateClause()
+	}
+	return "INSERT INTO" + " " + table + " (" + strings.Join(names, ", ") + ") VALUES " + placeholders(rows, len(columns)) + clause
+}
+
+// args returns the values bound to the placeholders of insertQuery
+func (l *layout) args(rows []row) []interface{} {
+	columns := l.insertColumns()
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for i := range rows {
+		for _, c := range columns {
+			args = append(args, c.value(&rows[i]))
+		}
+	}
+	return args
+}
+
+// numericValue returns numeric data of the row, or nil when it can't be stored in a DOUBLE column
+func numericValue(r *row) interface{} {
+	f, ok := interfaceToFloat(r.data)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+// textValue returns the value as text when it's not stored as a number
+func textValue(r *row) interface{} {
+	if numericValue(r) != nil {
+		return nil
+	}
+	return r.value
+}
@@ -0,0 +1,86 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLayout(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC)
+
+	Convey("Legacy layout", t, func() {
+		l, err := newLayout(schemaLegacy, tagsNone)
+		So(err, ShouldBeNil)
+
+		Convey("So the table should have four VARCHAR columns", func() {
+			So(l.createQuery("db.info"), ShouldEqual, "CREATE TABLE IF NOT EXISTS db.info (timestamp VARCHAR(200), source_column VARCHAR(200), key_column VARCHAR(200), value_column VARCHAR(200))")
+		})
+		Convey("So a single row should have one group of placeholders", func() {
+			So(l.insertQuery("info", 1), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_column) VALUES ( ?, ?, ?, ? )")
+		})
+		Convey("So multiple rows should have one group of placeholders per row", func() {
+			So(l.insertQuery("info", 3), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_column) VALUES ( ?, ?, ?, ? ), ( ?, ?, ?, ? ), ( ?, ?, ?, ? )")
+		})
+		Convey("So args should follow the order of the columns", func() {
+			args := l.args([]row{{timestamp: ts, source: "host", key: "a, b", value: "1"}, {timestamp: ts, source: "host", key: "a, c", value: "foo"}})
+			So(args, ShouldResemble, []interface{}{ts, "host", "a, b", "1", ts, "host", "a, c", "foo"})
+		})
+		Convey("So the batch size should be limited by the placeholders", func() {
+			So(l.maxBatchSize(), ShouldEqual, 16383)
+		})
+	})
+
+	Convey("Typed layout", t, func() {
+		l, err := newLayout(schemaTyped, tagsNone)
+		So(err, ShouldBeNil)
+
+		Convey("So the table should have a primary key and indexes", func() {
+			q := l.createQuery("db.info")
+			So(q, ShouldStartWith, "CREATE TABLE IF NOT EXISTS db.info (id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, timestamp DATETIME(6) NOT NULL")
+			So(q, ShouldContainSubstring, "PRIMARY KEY (id)")
+			So(q, ShouldContainSubstring, "INDEX namespace_timestamp (key_column, timestamp)")
+			So(q, ShouldContainSubstring, "INDEX source_timestamp (source_column, timestamp)")
+			So(q, ShouldContainSubstring, "INDEX timestamp (timestamp)")
+		})
+		Convey("So the auto-increment id should not be inserted", func() {
+			So(l.insertQuery("info", 1), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_numeric, value_column) VALUES ( ?, ?, ?, ?, ? )")
+		})
+		Convey("So numeric values should go to the numeric column", func() {
+			args := l.args([]row{{timestamp: ts, source: "host", key: "a", value: "1.5", data: 1.5}, {timestamp: ts, source: "host", key: "b", value: "2", data: int32(2)}})
+			So(args, ShouldResemble, []interface{}{ts, "host", "a", 1.5, nil, ts, "host", "b", float64(2), nil})
+		})
+		Convey("So other values should go to the text column", func() {
+			args := l.args([]row{{timestamp: ts, source: "host", key: "a", value: "1.5", data: "1.5"}, {timestamp: ts, source: "host", key: "b", value: "NaN", data: math.NaN()}})
+			So(args, ShouldResemble, []interface{}{ts, "host", "a", nil, "1.5", ts, "host", "b", nil, "NaN"})
+		})
+	})
+
+	Convey("Unknown layout should return an error", t, func() {
+		_, err := newLayout("unknown", tagsNone)
+		So(err, ShouldNotBeNil)
+	})
+}
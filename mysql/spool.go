@@ -0,0 +1,241 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap/control/plugin"
+)
+
+const (
+	// evictOldest drops the oldest spooled payloads to make room for a new one
+	evictOldest = "drop_oldest"
+	// evictNewest drops the new payload when the spool is full
+	evictNewest = "drop_newest"
+
+	spoolFileExt = ".spool"
+)
+
+// spool keeps payloads which cannot be delivered in a bounded local directory, one length-prefixed gob file per payload
+type spool struct {
+	sync.Mutex
+	dir      string
+	maxSize  int64
+	eviction string
+	// next is the sequence number of the next spooled payload, file names keep the order of payloads
+	next uint64
+}
+
+func checkEviction(eviction string) error {
+	switch eviction {
+	case evictOldest, evictNewest:
+		return nil
+	}
+	return fmt.Errorf("Unknown spool_eviction '%s', supported values: %s, %s", eviction, evictOldest, evictNewest)
+}
+
+// spoolDir returns the directory under path keeping payloads of the MySQL table described by c, it depends only on
+// the server, database and table; payloads are replayed with the settings of the task publishing to the table then,
+// so that changing other settings doesn't leave payloads behind
+func spoolDir(path string, c *config) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s.%s", c.address(), c.database, c.tableName)
+	return filepath.Join(path, fmt.Sprintf("%016x", h.Sum64()))
+}
+
+func newSpool(dir string, maxSize int64, eviction string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	sp := &spool{dir: dir, maxSize: maxSize, eviction: eviction}
+	files, _, err := sp.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last, _ := strconv.ParseUint(strings.TrimSuffix(files[len(files)-1], spoolFileExt), 10, 64)
+		sp.next = last + 1
+	}
+	return sp, nil
+}
+
+// setLimits changes the maximum size and eviction of the spool to the latest settings of the tasks using it
+func (sp *spool) setLimits(maxSize int64, eviction string) {
+	sp.Lock()
+	defer sp.Unlock()
+	sp.maxSize = maxSize
+	sp.eviction = eviction
+}
+
+// files returns names of spooled payloads, oldest first, and their total size
+func (sp *spool) files() ([]string, int64, error) {
+	infos, err := ioutil.ReadDir(sp.dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var names []string
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != spoolFileExt {
+			continue
+		}
+		names = append(names, info.Name())
+		size += info.Size()
+	}
+	sort.Strings(names)
+	return names, size, nil
+}
+
+// push spools metrics, evicting payloads when the spool would exceed its maximum size
+func (sp *spool) push(metrics []plugin.MetricType) error {
+	data, err := encodePayload(metrics)
+	if err != nil {
+		return err
+	}
+
+	sp.Lock()
+	defer sp.Unlock()
+
+	if int64(len(data)) > sp.maxSize {
+		return fmt.Errorf("Cannot spool %d metrics, payload of %d bytes exceeds spool size of %d bytes", len(metrics), len(data), sp.maxSize)
+	}
+	files, size, err := sp.files()
+	if err != nil {
+		return err
+	}
+	for size+int64(len(data)) > sp.maxSize {
+		if sp.eviction == evictNewest {
+			return fmt.Errorf("Cannot spool %d metrics, spool %s is full", len(metrics), sp.dir)
+		}
+		info, err := os.Stat(filepath.Join(sp.dir, files[0]))
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(sp.dir, files[0])); err != nil {
+			return err
+		}
+		size -= info.Size()
+		files = files[1:]
+	}
+
+	if err := writeFile(filepath.Join(sp.dir, fmt.Sprintf("%020d%s", sp.next, spoolFileExt)), data); err != nil {
+		return err
+	}
+	sp.next++
+	return nil
+}
+
+// read returns the metrics of a spooled payload
+func (sp *spool) read(name string) ([]plugin.MetricType, error) {
+	data, err := ioutil.ReadFile(filepath.Join(sp.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || binary.BigEndian.Uint64(data) != uint64(len(data)-8) {
+		return nil, fmt.Errorf("Spooled payload %s is corrupted", name)
+	}
+	var metrics []plugin.MetricType
+	if err := gob.NewDecoder(bytes.NewReader(data[8:])).Decode(&metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// replay passes spooled payloads, oldest first, to publish and removes the delivered ones;
+// it stops at the first payload failed with a transient error, keeping the metrics publish didn't write
+func (sp *spool) replay(publish func([]plugin.MetricType) ([]plugin.MetricType, error)) error {
+	sp.Lock()
+	defer sp.Unlock()
+
+	logger := log.New()
+	files, _, err := sp.files()
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		path := filepath.Join(sp.dir, name)
+		metrics, err := sp.read(name)
+		if err != nil {
+			// a payload which cannot be read would block the spool forever
+			logger.Printf("Error: dropped spooled payload %s, err=%v", path, err)
+			os.Remove(path)
+			continue
+		}
+
+		remaining, err := publish(metrics)
+		if err != nil && retryable(err) {
+			if len(remaining) < len(metrics) {
+				if err := sp.rewrite(path, remaining); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+		if err != nil {
+			logger.Printf("Error: dropped spooled payload %s, err=%v", path, err)
+		}
+		os.Remove(path)
+	}
+	return nil
+}
+
+// rewrite replaces a spooled payload with the given metrics
+func (sp *spool) rewrite(path string, metrics []plugin.MetricType) error {
+	data, err := encodePayload(metrics)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// encodePayload returns gob encoded metrics prefixed with their length
+func encodePayload(metrics []plugin.MetricType) ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	if err := gob.NewEncoder(&buf).Encode(metrics); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint64(data, uint64(len(data)-8))
+	return data, nil
+}
+
+// writeFile writes to a temporary file first, so a crash never leaves a truncated payload to replay
+func writeFile(path string, data []byte) error {
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
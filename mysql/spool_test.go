@@ -0,0 +1,187 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+	"github.com/intelsdi-x/snap/core/ctypes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func spoolTestMetrics(names ...string) []plugin.MetricType {
+	metrics := make([]plugin.MetricType, len(names))
+	for i, name := range names {
+		metrics[i] = *plugin.NewMetricType(core.NewNamespace("test", name), time.Unix(1470000000, 0), nil, "", 1)
+	}
+	return metrics
+}
+
+func spooledNames(metrics []plugin.MetricType) []string {
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.Namespace()[1].Value
+	}
+	return names
+}
+
+func TestSpool(t *testing.T) {
+	Convey("Keep payloads of a table in one spool", t, func() {
+		cfg := defaultTestConfig()
+		c1, err := parseConfig(cfg)
+		So(err, ShouldBeNil)
+
+		Convey("So changing how metrics are written or spooled should keep the spool", func() {
+			cfg["schema"] = ctypes.ConfigValueStr{Value: schemaTyped}
+			cfg["spool_max_size"] = ctypes.ConfigValueInt{Value: 200}
+			c2, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(spoolDir("/var/spool", c2), ShouldEqual, spoolDir("/var/spool", c1))
+		})
+		Convey("So other tables should get spools of their own", func() {
+			cfg["tablename"] = ctypes.ConfigValueStr{Value: "info1"}
+			c2, err := parseConfig(cfg)
+			So(err, ShouldBeNil)
+			So(spoolDir("/var/spool", c2), ShouldNotEqual, spoolDir("/var/spool", c1))
+		})
+		Convey("So the spool should follow the latest spool settings", func() {
+			dir, err := ioutil.TempDir("", "snap-mysql-spool")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			cfg["spool_path"] = ctypes.ConfigValueStr{Value: dir}
+			c1, _ := parseConfig(cfg)
+			cfg["spool_max_size"] = ctypes.ConfigValueInt{Value: 200}
+			cfg["spool_eviction"] = ctypes.ConfigValueStr{Value: evictNewest}
+			c2, _ := parseConfig(cfg)
+
+			s := NewMySQLPublisher()
+			sp1, err := s.spool(c1)
+			So(err, ShouldBeNil)
+			sp2, err := s.spool(c2)
+			So(err, ShouldBeNil)
+			So(sp2, ShouldEqual, sp1)
+			So(sp2.maxSize, ShouldEqual, c2.spoolMaxSize)
+			So(sp2.eviction, ShouldEqual, evictNewest)
+		})
+	})
+
+	Convey("Spool payloads on disk", t, func() {
+		dir, err := ioutil.TempDir("", "snap-mysql-spool")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		sp, err := newSpool(dir, 1<<20, evictOldest)
+		So(err, ShouldBeNil)
+
+		var replayed [][]string
+		publishAll := func(metrics []plugin.MetricType) ([]plugin.MetricType, error) {
+			replayed = append(replayed, spooledNames(metrics))
+			return nil, nil
+		}
+
+		Convey("So payloads should be replayed in order and removed", func() {
+			So(sp.push(spoolTestMetrics("a", "b")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("c")), ShouldBeNil)
+
+			So(sp.replay(publishAll), ShouldBeNil)
+			So(replayed, ShouldResemble, [][]string{{"a", "b"}, {"c"}})
+			files, _, _ := sp.files()
+			So(files, ShouldBeEmpty)
+		})
+		Convey("So a reopened spool should continue the sequence of payloads", func() {
+			So(sp.push(spoolTestMetrics("a")), ShouldBeNil)
+			reopened, err := newSpool(dir, 1<<20, evictOldest)
+			So(err, ShouldBeNil)
+			So(reopened.push(spoolTestMetrics("b")), ShouldBeNil)
+
+			So(reopened.replay(publishAll), ShouldBeNil)
+			So(replayed, ShouldResemble, [][]string{{"a"}, {"b"}})
+		})
+		Convey("So a transient error should stop replay and keep the metrics which were not written", func() {
+			So(sp.push(spoolTestMetrics("a", "b", "c")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("d")), ShouldBeNil)
+
+			err := sp.replay(func(metrics []plugin.MetricType) ([]plugin.MetricType, error) {
+				return metrics[1:], &mysqldriver.MySQLError{Number: errServerGone}
+			})
+			So(err, ShouldNotBeNil)
+
+			So(sp.replay(publishAll), ShouldBeNil)
+			So(replayed, ShouldResemble, [][]string{{"b", "c"}, {"d"}})
+		})
+		Convey("So a payload failed with a fatal error should be dropped", func() {
+			So(sp.push(spoolTestMetrics("a")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("b")), ShouldBeNil)
+
+			calls := 0
+			err := sp.replay(func(metrics []plugin.MetricType) ([]plugin.MetricType, error) {
+				calls++
+				return metrics, &mysqldriver.MySQLError{Number: 1146}
+			})
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 2)
+			files, _, _ := sp.files()
+			So(files, ShouldBeEmpty)
+		})
+		Convey("So a corrupted payload should be dropped", func() {
+			So(sp.push(spoolTestMetrics("a")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("b")), ShouldBeNil)
+			files, _, _ := sp.files()
+			So(ioutil.WriteFile(filepath.Join(dir, files[0]), []byte("garbage"), 0600), ShouldBeNil)
+
+			So(sp.replay(publishAll), ShouldBeNil)
+			So(replayed, ShouldResemble, [][]string{{"b"}})
+		})
+		Convey("So a full spool should evict the oldest payloads", func() {
+			data, _ := encodePayload(spoolTestMetrics("a"))
+			sp.maxSize = int64(2 * len(data))
+			So(sp.push(spoolTestMetrics("a")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("b")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("c")), ShouldBeNil)
+
+			So(sp.replay(publishAll), ShouldBeNil)
+			So(replayed, ShouldResemble, [][]string{{"b"}, {"c"}})
+		})
+		Convey("So a full spool should reject new payloads when the newest are dropped", func() {
+			data, _ := encodePayload(spoolTestMetrics("a"))
+			sp.maxSize = int64(2 * len(data))
+			sp.eviction = evictNewest
+			So(sp.push(spoolTestMetrics("a")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("b")), ShouldBeNil)
+			So(sp.push(spoolTestMetrics("c")), ShouldNotBeNil)
+
+			So(sp.replay(publishAll), ShouldBeNil)
+			So(replayed, ShouldResemble, [][]string{{"a"}, {"b"}})
+		})
+		Convey("So a payload larger than the spool should be rejected", func() {
+			sp.maxSize = 16
+			So(sp.push(spoolTestMetrics("a")), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,237 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// tagsNone stores only the plugin_running_on tag as the source of a metric
+	tagsNone = "none"
+	// tagsJSON stores all tags of a metric in a JSON column
+	tagsJSON = "json"
+	// tagsTable stores every distinct set of tags once in a separate table, referenced by tags_id
+	tagsTable = "table"
+
+	// tagsTableSuffix is appended to the metrics table name to get the name of the tags table
+	tagsTableSuffix = "_tags"
+	// tagSetsTableSuffix is appended to the metrics table name to get the name of the table assigning identifiers to sets of tags
+	tagSetsTableSuffix = "_tag_sets"
+	// tagsColumnsPerRow is the number of placeholders bound for every inserted tag
+	tagsColumnsPerRow = 3
+)
+
+// tagsColumns returns the columns and indexes added to the metrics table to store tags
+func tagsColumns(mode string) ([]column, []string, error) {
+	switch mode {
+	case tagsNone:
+		return nil, nil, nil
+	case tagsJSON:
+		return []column{{"tags", "JSON NULL", tagsJSONValue}}, nil, nil
+	case tagsTable:
+		return []column{{"tags_id", "BIGINT NOT NULL", func(r *row) interface{} { return r.tagsID }}},
+			[]string{"INDEX tags_id (tags_id)"}, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown tags storage '%s', supported values: %s, %s, %s", mode, tagsNone, tagsJSON, tagsTable)
+}
+
+// tagsJSONValue returns the tags of a row encoded as a JSON object
+func tagsJSONValue(r *row) interface{} {
+	if len(r.tags) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(r.tags)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// canonicalTags returns the key of a set of tags, equal sets of tags share the key and different ones never do
+func canonicalTags(tags map[string]string) string {
+	var b bytes.Buffer
+	for _, k := range sortedKeys(tags) {
+		// lengths prefix the keys and values, so that they can hold any character
+		fmt.Fprintf(&b, "%d:%s%d:%s", len(k), k, len(tags[k]), tags[k])
+	}
+	return b.String()
+}
+
+// canonicalHash returns the hash of a canonical key stored in the unique key of the tag sets table, canonical keys are
+// too long to be indexed themselves
+func canonicalHash(key string) []byte {
+	h := sha256.Sum256([]byte(key))
+	return h[:]
+}
+
+// tagsTableColumns and tagSetsTableColumns are the columns of the tags tables written by the publisher
+var (
+	tagsTableColumns    = []column{{name: "tags_id", definition: "BIGINT"}, {name: "tag_key", definition: "VARCHAR"}, {name: "tag_value", definition: "VARCHAR"}}
+	tagSetsTableColumns = []column{{name: "tags_id", definition: "BIGINT"}, {name: "canonical_key", definition: "BINARY"}}
+)
+
+// tagSetsTableQuery returns the statement creating the table assigning identifiers to sets of tags if it's not already there
+func tagSetsTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (tags_id BIGINT NOT NULL AUTO_INCREMENT, canonical_key BINARY(32) NOT NULL, " +
+		"PRIMARY KEY (tags_id), UNIQUE KEY canonical_key (canonical_key))"
+}
+
+// tagSetsInsertQuery returns a multi-row INSERT statement for the given number of sets of tags, sets already stored are ignored
+func tagSetsInsertQuery(table string, sets int) string {
+	return "INSERT IGNORE INTO" + " " + table + " (canonical_key) VALUES " + placeholders(sets, 1)
+}
+
+// tagSetsSelectQuery returns the query reading the identifiers of the given number of sets of tags; it's a locking read,
+// so that within a transaction it sees sets stored by other transactions after its snapshot was taken
+func tagSetsSelectQuery(table string, sets int) string {
+	return "SELECT tags_id, canonical_key FROM " + table + " WHERE canonical_key IN (" + strings.TrimSuffix(strings.Repeat("?, ", sets), ", ") + ") LOCK IN SHARE MODE"
+}
+
+// tagsTableQuery returns the statement creating the tags table if it's not already there
+func tagsTableQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS" + " " + table + " (tags_id BIGINT NOT NULL, tag_key VARCHAR(255) NOT NULL, tag_value VARCHAR(255) NOT NULL, PRIMARY KEY (tags_id, tag_key))"
+}
+
+// tagsInsertQuery returns a multi-row INSERT statement for the given number of tags, tag sets already stored are ignored
+func tagsInsertQuery(table string, tags int) string {
+	return "INSERT IGNORE INTO" + " " + table + " (tags_id, tag_key, tag_value) VALUES " + placeholders(tags, tagsColumnsPerRow)
+}
+
+// cachedTags assigns the identifiers of cached sets of tags to rows, it returns the sets of tags of the other rows
+// by their canonical keys
+func (d *destination) cachedTags(rows []row) map[string]map[string]string {
+	unknown := map[string]map[string]string{}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range rows {
+		key := canonicalTags(rows[i].tags)
+		if id, ok := d.storedTags[key]; ok {
+			rows[i].tagsID = id
+		} else {
+			unknown[key] = rows[i].tags
+		}
+	}
+	return unknown
+}
+
+// tagSetIDs stores the sets of tags by their canonical keys in the tag sets table of d, unless they're there already,
+// and returns their identifiers
+func (d *destination) tagSetIDs(ex queryer, keys []string) (map[string]int64, error) {
+	byHash := map[string]string{}
+	var args []interface{}
+	for _, key := range keys {
+		h := canonicalHash(key)
+		byHash[string(h)] = key
+		args = append(args, h)
+	}
+	if err := execBatches(ex, args, 1, func(n int) string {
+		return tagSetsInsertQuery(d.tagSetsTableName, n)
+	}); err != nil {
+		return nil, err
+	}
+
+	ids := map[string]int64{}
+	for len(args) > 0 {
+		n := len(args)
+		if n > maxPlaceholders {
+			n = maxPlaceholders
+		}
+		if err := scanTagSetIDs(ex, tagSetsSelectQuery(d.tagSetsTableName, n), args[:n], byHash, ids); err != nil {
+			return nil, err
+		}
+		args = args[n:]
+	}
+	if len(ids) != len(keys) {
+		return nil, fmt.Errorf("Cannot read identifiers of %d sets of tags from table %s", len(keys)-len(ids), d.tagSetsTableName)
+	}
+	return ids, nil
+}
+
+func scanTagSetIDs(ex queryer, query string, args []interface{}, byHash map[string]string, ids map[string]int64) error {
+	rows, err := ex.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var h []byte
+		if err := rows.Scan(&id, &h); err != nil {
+			return err
+		}
+		if key, ok := byHash[string(h)]; ok {
+			ids[key] = id
+		}
+	}
+	return rows.Err()
+}
+
+// insertTags assigns identifiers to the sets of tags of rows, the ones which are not cached are stored in the tags
+// tables of d; it returns the identifiers of the stored ones by their canonical keys
+func (d *destination) insertTags(ex queryer, rows []row) (map[string]int64, error) {
+	unknown := d.cachedTags(rows)
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(unknown))
+	for key := range unknown {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the lock isn't held while writing, concurrent publishes storing the same tags are ignored by MySQL
+	ids, err := d.tagSetIDs(ex, keys)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	for _, key := range keys {
+		tags := unknown[key]
+		for _, k := range sortedKeys(tags) {
+			args = append(args, ids[key], k, tags[k])
+		}
+	}
+	if err := execBatches(ex, args, tagsColumnsPerRow, func(n int) string {
+		return tagsInsertQuery(d.tagsTableName, n)
+	}); err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if id, ok := ids[canonicalTags(rows[i].tags)]; ok {
+			rows[i].tagsID = id
+		}
+	}
+	return ids, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTags(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC)
+	tags := map[string]string{"plugin_running_on": "host", "rack": "r1"}
+
+	Convey("Identify sets of tags", t, func() {
+		Convey("So equal sets of tags should share the canonical key", func() {
+			So(canonicalTags(tags), ShouldEqual, canonicalTags(map[string]string{"rack": "r1", "plugin_running_on": "host"}))
+		})
+		Convey("So different sets of tags should not share the canonical key", func() {
+			So(canonicalTags(tags), ShouldNotEqual, canonicalTags(map[string]string{"plugin_running_on": "host", "rack": "r2"}))
+			So(canonicalTags(map[string]string{"ab": "c"}), ShouldNotEqual, canonicalTags(map[string]string{"a": "bc"}))
+			So(canonicalTags(map[string]string{"a": "1:b"}), ShouldNotEqual, canonicalTags(map[string]string{"a": "", "b": ""}))
+		})
+		Convey("So the identifiers should be assigned by the tag sets table", func() {
+			So(tagSetsTableQuery("info_tag_sets"), ShouldEqual, "CREATE TABLE IF NOT EXISTS info_tag_sets (tags_id BIGINT NOT NULL AUTO_INCREMENT, "+
+				"canonical_key BINARY(32) NOT NULL, PRIMARY KEY (tags_id), UNIQUE KEY canonical_key (canonical_key))")
+			So(tagSetsInsertQuery("info_tag_sets", 2), ShouldEqual, "INSERT IGNORE INTO info_tag_sets (canonical_key) VALUES ( ? ), ( ? )")
+			So(tagSetsSelectQuery("info_tag_sets", 2), ShouldEqual, "SELECT tags_id, canonical_key FROM info_tag_sets WHERE canonical_key IN (?, ?) LOCK IN SHARE MODE")
+			So(canonicalHash(canonicalTags(tags)), ShouldHaveLength, 32)
+		})
+	})
+
+	Convey("Store tags in a JSON column", t, func() {
+		l, err := newLayout(schemaLegacy, tagsJSON)
+		So(err, ShouldBeNil)
+		So(l.createQuery("info"), ShouldContainSubstring, "tags JSON NULL")
+		So(l.insertQuery("info", 1), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_column, tags) VALUES ( ?, ?, ?, ?, ? )")
+		args := l.args([]row{{timestamp: ts, source: "host", key: "a", value: "1", tags: tags}, {timestamp: ts, source: "host", key: "a", value: "1"}})
+		So(args[4], ShouldEqual, `{"plugin_running_on":"host","rack":"r1"}`)
+		So(args[9], ShouldBeNil)
+	})
+
+	Convey("Store tags in a separate table", t, func() {
+		l, err := newLayout(schemaTyped, tagsTable)
+		So(err, ShouldBeNil)
+		So(l.createQuery("info"), ShouldContainSubstring, "tags_id BIGINT NOT NULL")
+		So(l.createQuery("info"), ShouldContainSubstring, "INDEX tags_id (tags_id)")
+		args := l.args([]row{{timestamp: ts, source: "host", key: "a", value: "1", tags: tags, tagsID: 42}})
+		So(args[len(args)-1], ShouldEqual, int64(42))
+		So(tagsInsertQuery("info_tags", 2), ShouldEqual, "INSERT IGNORE INTO info_tags (tags_id, tag_key, tag_value) VALUES ( ?, ?, ? ), ( ?, ?, ? )")
+	})
+
+	Convey("Cache stored tags shared by concurrent publishes", t, func() {
+		d := &destination{tagsTableName: "info_tags", storedTags: map[string]int64{}}
+		ids := map[string]int64{}
+		for i := 0; i < 5; i++ {
+			ids[canonicalTags(map[string]string{"rack": string('a' + byte(i))})] = int64(i + 1)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rows := []row{{tags: map[string]string{"rack": string('a' + byte(i%5))}}}
+				// identifiers of unknown sets are stored the way insertTags does once they're read
+				st := &storedCaches{dest: d, tags: map[string]int64{}}
+				for key := range d.cachedTags(rows) {
+					st.tags[key] = ids[key]
+				}
+				st.apply()
+			}(i)
+		}
+		wg.Wait()
+		So(d.storedTags, ShouldResemble, ids)
+
+		Convey("So cached sets of tags should get their identifiers without being stored again", func() {
+			rows := []row{{tags: map[string]string{"rack": "c"}}, {tags: map[string]string{"rack": "r1"}}}
+			unknown := d.cachedTags(rows)
+			So(rows[0].tagsID, ShouldEqual, 3)
+			So(unknown, ShouldResemble, map[string]map[string]string{canonicalTags(rows[1].tags): rows[1].tags})
+		})
+	})
+
+	Convey("Unknown tags storage should return an error", t, func() {
+		_, err := newLayout(schemaLegacy, "unknown")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// countingExecer counts the statements executed concurrently by publishes, without executing them
+type countingExecer struct {
+	mu    sync.Mutex
+	execs int
+}
+
+func (ex *countingExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.execs++
+	return nil, nil
+}
@@ -0,0 +1,114 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// tlsDisabled connects without TLS
+	tlsDisabled = "disabled"
+	// tlsPreferred uses TLS without verifying the server certificate, falling back to a plain connection when the server doesn't support TLS
+	tlsPreferred = "preferred"
+	// tlsRequired uses TLS and verifies the server certificate against tls_ca, or the system roots when it's not set
+	tlsRequired = "required"
+	// tlsSkipVerify uses TLS without verifying the server certificate
+	tlsSkipVerify = "skip-verify"
+)
+
+func checkTLS(c *config) error {
+	switch c.tlsMode {
+	case tlsDisabled:
+		if c.tlsCA != "" || c.tlsCert != "" || c.tlsKey != "" {
+			return fmt.Errorf("tls_ca, tls_cert and tls_key require tls_mode other than %s", tlsDisabled)
+		}
+	case tlsPreferred, tlsRequired, tlsSkipVerify:
+	default:
+		return fmt.Errorf("Unknown tls_mode '%s', supported values: %s, %s, %s, %s", c.tlsMode, tlsDisabled, tlsPreferred, tlsRequired, tlsSkipVerify)
+	}
+	if (c.tlsCert == "") != (c.tlsKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS settings of connections described by c, nil when TLS is disabled
+func tlsConfig(c *config) (*tls.Config, error) {
+	if c.tlsMode == tlsDisabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: c.tlsMode != tlsRequired}
+	if c.tlsCA != "" {
+		pem, err := ioutil.ReadFile(c.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in tls_ca %s", c.tlsCA)
+		}
+	}
+	if c.tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.tlsCert, c.tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// tlsConfigs numbers the TLS configs registered with the driver
+var tlsConfigs uint64
+
+// registerTLSConfig registers the TLS settings of c with the driver and returns the name to reference them in a DSN,
+// an empty name when TLS is disabled. Connections are initialized while the pools of other ones read the registry
+// to dial, the driver guards it since v1.4
+func registerTLSConfig(c *config) (string, error) {
+	cfg, err := tlsConfig(c)
+	if cfg == nil || err != nil {
+		return "", err
+	}
+
+	// every connection registers a name of its own, closing it doesn't deregister the config of another one
+	name := fmt.Sprintf("snap-mysql-%d", atomic.AddUint64(&tlsConfigs, 1))
+	if err := mysqldriver.RegisterTLSConfig(name, cfg); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// deregisterTLSConfig removes the TLS settings registered under name from the driver, once the connection using them is closed
+func deregisterTLSConfig(name string) {
+	mysqldriver.DeregisterTLSConfig(name)
+}
+
+// fallbackToPlain reports whether a connection failed with err should be retried without TLS
+func fallbackToPlain(c *config, err error) bool {
+	return c.tlsMode == tlsPreferred && err == mysqldriver.ErrNoTLS
+}
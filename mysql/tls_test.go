@@ -0,0 +1,226 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTestCert writes a self-signed certificate valid for 127.0.0.1 and its key as PEM files to dir
+func writeTestCert(dir, name string) (certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// startTestTLSServer accepts connections on a local port and completes TLS handshakes using server,
+// client certificates are required and verified against clientCA when it's set
+func startTestTLSServer(server tls.Certificate, clientCA *x509.CertPool) (net.Listener, error) {
+	cfg := &tls.Config{Certificates: []tls.Certificate{server}}
+	if clientCA != nil {
+		cfg.ClientCAs = clientCA
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	return l, nil
+}
+
+// handshake connects to addr using the TLS settings of c
+func handshake(addr string, c *config) error {
+	cfg, err := tlsConfig(c)
+	if err != nil {
+		return err
+	}
+	cfg.ServerName = "127.0.0.1"
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the server verifies the client certificate after the client finished its part of the handshake
+	_, err = conn.Read(make([]byte, 1))
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func TestTLS(t *testing.T) {
+	Convey("Connect with TLS to a server using a self-signed certificate", t, func() {
+		dir, err := ioutil.TempDir("", "snap-mysql-tls")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		serverCert, serverKey, err := writeTestCert(dir, "server")
+		So(err, ShouldBeNil)
+		clientCert, clientKey, err := writeTestCert(dir, "client")
+		So(err, ShouldBeNil)
+		otherCert, otherKey, err := writeTestCert(dir, "other")
+		So(err, ShouldBeNil)
+
+		server, err := tls.LoadX509KeyPair(serverCert, serverKey)
+		So(err, ShouldBeNil)
+		clientPEM, _ := ioutil.ReadFile(clientCert)
+		clientCA := x509.NewCertPool()
+		clientCA.AppendCertsFromPEM(clientPEM)
+
+		Convey("So required mode should verify the server certificate against tls_ca", func() {
+			l, err := startTestTLSServer(server, nil)
+			So(err, ShouldBeNil)
+			defer l.Close()
+
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsRequired, tlsCA: serverCert}), ShouldBeNil)
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsRequired, tlsCA: otherCert}), ShouldNotBeNil)
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsRequired}), ShouldNotBeNil)
+		})
+		Convey("So preferred and skip-verify modes should accept any server certificate", func() {
+			l, err := startTestTLSServer(server, nil)
+			So(err, ShouldBeNil)
+			defer l.Close()
+
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsPreferred}), ShouldBeNil)
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsSkipVerify, tlsCA: otherCert}), ShouldBeNil)
+		})
+		Convey("So the client certificate should be presented to the server", func() {
+			l, err := startTestTLSServer(server, clientCA)
+			So(err, ShouldBeNil)
+			defer l.Close()
+
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsRequired, tlsCA: serverCert, tlsCert: clientCert, tlsKey: clientKey}), ShouldBeNil)
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsRequired, tlsCA: serverCert, tlsCert: otherCert, tlsKey: otherKey}), ShouldNotBeNil)
+			So(handshake(l.Addr().String(), &config{tlsMode: tlsRequired, tlsCA: serverCert}), ShouldNotBeNil)
+		})
+		Convey("So missing or invalid files should return an error", func() {
+			_, err := tlsConfig(&config{tlsMode: tlsRequired, tlsCA: filepath.Join(dir, "missing.crt")})
+			So(err, ShouldNotBeNil)
+			_, err = tlsConfig(&config{tlsMode: tlsRequired, tlsCA: serverKey})
+			So(err, ShouldNotBeNil)
+			_, err = tlsConfig(&config{tlsMode: tlsRequired, tlsCert: clientCert, tlsKey: serverKey})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So disabled mode should not use TLS", func() {
+			cfg, err := tlsConfig(&config{tlsMode: tlsDisabled})
+			So(err, ShouldBeNil)
+			So(cfg, ShouldBeNil)
+			name, err := registerTLSConfig(&config{tlsMode: tlsDisabled})
+			So(err, ShouldBeNil)
+			So(name, ShouldBeEmpty)
+		})
+		Convey("So registered settings should be referenced by the connection URL", func() {
+			name, err := registerTLSConfig(&config{tlsMode: tlsRequired, tlsCA: serverCert})
+			So(err, ShouldBeNil)
+			defer deregisterTLSConfig(name)
+			So(getMySQLConnectionURL(&config{username: "root", password: "secret", hostname: "127.0.0.1", port: "3306"}, name), ShouldEqual, "root:secret@tcp(127.0.0.1:3306)/?tls="+name)
+		})
+		Convey("So settings should be registered while pools of other connections dial", func() {
+			name, err := registerTLSConfig(&config{tlsMode: tlsRequired, tlsCA: serverCert})
+			So(err, ShouldBeNil)
+			defer deregisterTLSConfig(name)
+			url := getMySQLConnectionURL(&config{username: "root", hostname: "127.0.0.1", port: "3306"}, name)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					other, _ := registerTLSConfig(&config{tlsMode: tlsSkipVerify})
+					deregisterTLSConfig(other)
+				}
+			}()
+			// pools parse their DSN to dial every new connection
+			for i := 0; i < 100; i++ {
+				_, err := mysqldriver.ParseDSN(url)
+				So(err, ShouldBeNil)
+			}
+			<-done
+		})
+	})
+}
+
+func TestCheckTLS(t *testing.T) {
+	Convey("Validate TLS settings", t, func() {
+		So(checkTLS(&config{tlsMode: tlsDisabled}), ShouldBeNil)
+		So(checkTLS(&config{tlsMode: tlsRequired, tlsCA: "ca.pem", tlsCert: "client.pem", tlsKey: "client.key"}), ShouldBeNil)
+		So(checkTLS(&config{tlsMode: "verify-full"}), ShouldNotBeNil)
+		So(checkTLS(&config{tlsMode: tlsDisabled, tlsCA: "ca.pem"}), ShouldNotBeNil)
+		So(checkTLS(&config{tlsMode: tlsRequired, tlsCert: "client.pem"}), ShouldNotBeNil)
+	})
+}
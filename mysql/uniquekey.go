@@ -0,0 +1,103 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// duplicateIgnore keeps the stored row when a metric is written again
+	duplicateIgnore = "ignore"
+	// duplicateUpdate replaces the values of the stored row when a metric is written again
+	duplicateUpdate = "update"
+	// duplicateError fails the write of a metric which is already stored
+	duplicateError = "error"
+
+	// uniqueKeyName is the name of the unique key of metrics tables
+	uniqueKeyName = "metric"
+	// uniqueKeyDefinition identifies a stored metric by its namespace, source and timestamp
+	uniqueKeyDefinition = "UNIQUE KEY " + uniqueKeyName + " (key_column, source_column, timestamp)"
+	// ignoreClause keeps stored rows by assigning their primary key to itself; unlike INSERT IGNORE it doesn't turn
+	// errors other than duplicate keys, like truncated values, into warnings
+	ignoreClause = " ON DUPLICATE KEY UPDATE id = id"
+)
+
+// uniqueKeyColumns are the columns of the unique key, they're never updated
+var uniqueKeyColumns = map[string]bool{"key_column": true, "source_column": true, "timestamp": true}
+
+func checkOnDuplicate(policy string) error {
+	switch policy {
+	case duplicateIgnore, duplicateUpdate, duplicateError:
+		return nil
+	}
+	return fmt.Errorf("Unknown on_duplicate '%s', supported values: %s, %s, %s", policy, duplicateIgnore, duplicateUpdate, duplicateError)
+}
+
+// setUniqueKey adds the unique key to the layout, metrics written again are handled according to onDuplicate
+func (l *layout) setUniqueKey(onDuplicate string) {
+	l.indexes = append(l.indexes, uniqueKeyDefinition)
+	l.onDuplicate = onDuplicate
+}
+
+// duplicateClause returns the clause of INSERT replacing the values of stored rows which are not part of the unique key
+func (l *layout) duplicateClause() string {
+	var updates []string
+	for _, c := range l.insertColumns() {
+		if !uniqueKeyColumns[c.name] {
+			updates = append(updates, c.name+" = VALUES("+c.name+")")
+		}
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// addUniqueKey adds the unique key to an existing table which was created without it
+func (conn *connection) addUniqueKey(c *config, table string) error {
+	logger := log.New()
+	tableName := qualifiedName(c.database, table)
+	state, err := readTableState(conn.db, c.database, table)
+	if err != nil {
+		logger.Printf("Error: cannot read layout of table %v, err=%v", tableName, err)
+		return err
+	}
+	if len(state.columns) == 0 || state.indexes[uniqueKeyName] {
+		// a missing table is reported by the check of its columns
+		return nil
+	}
+
+	query := "ALTER TABLE " + tableName + " ADD " + uniqueKeyDefinition
+	switch {
+	case c.autoCreate == autoCreateNone:
+		logger.Printf("Table %v has no unique key %v, metrics written again are stored twice", tableName, uniqueKeyName)
+		return nil
+	case c.migrations == migrationsDryRun:
+		logger.Printf("Dry run, planned unique key of table %v: %v", tableName, query)
+		return nil
+	}
+	logger.Printf("Adding unique key %v to table %v", uniqueKeyName, tableName)
+	if _, err := conn.db.Exec(query); err != nil {
+		logger.Printf("Error: cannot add unique key to table %v, duplicated rows have to be removed first, err=%v", tableName, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUniqueKey(t *testing.T) {
+	Convey("Unique key of metrics tables", t, func() {
+		l, _ := newLayout(schemaTyped, tagsJSON)
+
+		Convey("So a layout without the unique key should insert duplicates", func() {
+			So(l.createQuery("info"), ShouldNotContainSubstring, "UNIQUE KEY")
+			So(l.insertQuery("info", 1), ShouldStartWith, "INSERT INTO info (")
+		})
+		Convey("So the unique key should identify metrics by namespace, source and timestamp", func() {
+			l.setUniqueKey(duplicateError)
+			So(l.createQuery("info"), ShouldEndWith, ", UNIQUE KEY metric (key_column, source_column, timestamp))")
+			So(l.insertQuery("info", 1), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_numeric, value_column, tags) VALUES ( ?, ?, ?, ?, ?, ? )")
+		})
+		Convey("So duplicates should be ignored", func() {
+			l.setUniqueKey(duplicateIgnore)
+			So(l.insertQuery("info", 1), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_numeric, value_column, tags) VALUES ( ?, ?, ?, ?, ?, ? ) "+
+				"ON DUPLICATE KEY UPDATE id = id")
+		})
+		Convey("So duplicates should update the values which are not part of the key", func() {
+			l.setUniqueKey(duplicateUpdate)
+			So(l.insertQuery("info", 2), ShouldEqual, "INSERT INTO info (timestamp, source_column, key_column, value_numeric, value_column, tags) VALUES ( ?, ?, ?, ?, ?, ? ), ( ?, ?, ?, ?, ?, ? )"+
+				" ON DUPLICATE KEY UPDATE value_numeric = VALUES(value_numeric), value_column = VALUES(value_column), tags = VALUES(tags)")
+		})
+		Convey("So an unknown on_duplicate should return an error", func() {
+			So(checkOnDuplicate(duplicateIgnore), ShouldBeNil)
+			So(checkOnDuplicate(duplicateUpdate), ShouldBeNil)
+			So(checkOnDuplicate(duplicateError), ShouldBeNil)
+			So(checkOnDuplicate("replace"), ShouldNotBeNil)
+		})
+	})
+}
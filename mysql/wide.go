@@ -0,0 +1,282 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// schemaWide stores the metrics of a timestamp and source in one row, with a column for every namespace
+	schemaWide = "wide"
+
+	// wideNameSeparator joins the elements of a namespace in the name of its column
+	wideNameSeparator = "/"
+	// wideHashLength is the number of characters of the hash suffixed to names of columns which would be ambiguous
+	wideHashLength = 17
+)
+
+// wideColumnsQuery returns the columns of a table with their types and the namespaces they store, kept in their comments
+const wideColumnsQuery = "SELECT COLUMN_NAME, DATA_TYPE, COLUMN_COMMENT FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"
+
+// wideColumn is a column of a wide table storing the values of one namespace
+type wideColumn struct {
+	name    string
+	numeric bool
+}
+
+// wideTable holds the columns of a wide table by the namespaces they store
+type wideTable struct {
+	// mu guards the columns, concurrent publishes may add columns to the same table
+	mu sync.Mutex
+
+	database string
+	table    string
+	// columns maps namespaces to their columns
+	columns map[string]wideColumn
+	// names holds the lower case names of all columns of the table, MySQL column names are case insensitive
+	names map[string]bool
+}
+
+// wideRow holds the values of all metrics of a timestamp and source, by the names of their columns
+type wideRow struct {
+	timestamp time.Time
+	source    string
+	values    map[string]interface{}
+}
+
+// load reads the columns of the table, columns without a namespace in their comments are not written by the publisher
+func (w *wideTable) load(db *sql.DB) error {
+	rows, err := db.Query(wideColumnsQuery, w.database, w.table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w.columns = map[string]wideColumn{}
+	w.names = map[string]bool{}
+	for rows.Next() {
+		var name, dataType, namespace string
+		if err := rows.Scan(&name, &dataType, &namespace); err != nil {
+			return err
+		}
+		w.names[strings.ToLower(name)] = true
+		if namespace != "" {
+			family := typeFamily(dataType)
+			w.columns[namespace] = wideColumn{name: name, numeric: family == "number" || family == "integer"}
+		}
+	}
+	return rows.Err()
+}
+
+// columnName returns the name of a new column storing namespace, names which are too long or already taken
+// are shortened and suffixed with a hash of the namespace key
+func (w *wideTable) columnName(key string, namespace []string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == 0 || r > 0xFFFF {
+			return '_'
+		}
+		return r
+	}, strings.Join(namespace, wideNameSeparator))
+	if utf8.RuneCountInString(name) <= maxIdentifierLength && !strings.HasSuffix(name, " ") && !w.names[strings.ToLower(name)] {
+		return name
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	if runes := []rune(name); len(runes) > maxIdentifierLength-wideHashLength {
+		name = string(runes[:maxIdentifierLength-wideHashLength])
+	}
+	return fmt.Sprintf("%s~%016x", strings.TrimRight(name, " "), h.Sum64())
+}
+
+// plan returns the clauses of ALTER TABLE adding the columns of new namespaces of rows and changing numeric columns
+// to text when they receive other values, with the columns they result in
+func (w *wideTable) plan(rows []row) ([]string, map[string]wideColumn) {
+	var clauses []string
+	planned := map[string]wideColumn{}
+	var order []string
+	for i := range rows {
+		r := &rows[i]
+		_, numeric := interfaceToFloat(r.data)
+		if col, ok := planned[r.key]; ok {
+			col.numeric = col.numeric && numeric
+			planned[r.key] = col
+			continue
+		}
+		if col, ok := w.columns[r.key]; ok {
+			if col.numeric && !numeric {
+				planned[r.key] = wideColumn{name: col.name}
+				order = append(order, r.key)
+			}
+			continue
+		}
+		name := w.columnName(r.key, r.namespace.Strings())
+		w.names[strings.ToLower(name)] = true
+		planned[r.key] = wideColumn{name: name, numeric: numeric}
+		order = append(order, r.key)
+	}
+
+	for _, key := range order {
+		col := planned[key]
+		definition := "TEXT NULL"
+		if col.numeric {
+			definition = "DOUBLE NULL"
+		}
+		if _, ok := w.columns[key]; ok {
+			clauses = append(clauses, "MODIFY "+quoteIdentifier(col.name)+" "+definition+" COMMENT "+quoteString(key))
+		} else {
+			clauses = append(clauses, "ADD COLUMN "+quoteIdentifier(col.name)+" "+definition+" COMMENT "+quoteString(key))
+		}
+	}
+	return clauses, planned
+}
+
+// pivot returns rows merged by their timestamps and sources, in order of their first metrics,
+// with the names of the columns they fill; a later value of a namespace replaces an earlier one
+func (w *wideTable) pivot(rows []row) ([]string, []wideRow) {
+	var names []string
+	added := map[string]bool{}
+	var wide []wideRow
+	index := map[string]int{}
+	for i := range rows {
+		r := &rows[i]
+		col := w.columns[r.key]
+		if !added[col.name] {
+			added[col.name] = true
+			names = append(names, col.name)
+		}
+
+		id := wideRowID(r)
+		n, ok := index[id]
+		if !ok {
+			n = len(wide)
+			index[id] = n
+			wide = append(wide, wideRow{timestamp: r.timestamp, source: r.source, values: map[string]interface{}{}})
+		}
+		if col.numeric {
+			wide[n].values[col.name] = numericValue(r)
+		} else {
+			wide[n].values[col.name] = r.value
+		}
+	}
+	return names, wide
+}
+
+// wideRowID identifies the wide row a row is merged into, like the primary key of the table does: timestamps are
+// stored with microseconds and sources are compared case insensitively
+func wideRowID(r *row) string {
+	return r.timestamp.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano) + "\x00" + strings.ToLower(r.source)
+}
+
+// wideInsertQuery returns a multi-row statement inserting the given number of rows or adding their values to existing rows,
+// values of columns missing in a row keep the stored ones
+func wideInsertQuery(table string, columns []string, rows int) string {
+	names := []string{"timestamp", "source_column"}
+	updates := make([]string, len(columns))
+	for i, c := range columns {
+		names = append(names, quoteIdentifier(c))
+		updates[i] = quoteIdentifier(c) + " = COALESCE(VALUES(" + quoteIdentifier(c) + "), " + quoteIdentifier(c) + ")"
+	}
+	return "INSERT INTO" + " " + table + " (" + strings.Join(names, ", ") + ") VALUES " + placeholders(rows, len(names)) +
+		" ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// quoteString returns s as a quoted string literal
+func quoteString(s string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "''").Replace(s) + "'"
+}
+
+// addWideColumns alters the wide table of d so that it can store the values of rows, it's done outside transactions
+// as ALTER TABLE commits them implicitly
+func (conn *connection) addWideColumns(d *destination, rows []row) error {
+	// the lock is held while altering the table, so that concurrent publishes don't add the same columns
+	d.wide.mu.Lock()
+	defer d.wide.mu.Unlock()
+	clauses, planned := d.wide.plan(rows)
+	if len(clauses) == 0 {
+		return nil
+	}
+	logger := log.New()
+	logger.Printf("Altering table %v for new namespaces: %s", d.tableName, strings.Join(clauses, ", "))
+	err := conn.retry.do(func() error {
+		_, err := conn.db.Exec("ALTER TABLE " + d.tableName + " " + strings.Join(clauses, ", "))
+		return err
+	})
+	if err != nil {
+		// another publisher may have added the columns, so they're read again
+		if lerr := d.wide.load(conn.db); lerr != nil {
+			logger.Printf("Error: cannot read columns of table %v, err=%v", d.tableName, lerr)
+			return err
+		}
+		if clauses, _ := d.wide.plan(rows); len(clauses) > 0 {
+			logger.Printf("Error: cannot alter table %v, err=%v", d.tableName, err)
+			return err
+		}
+		return nil
+	}
+	for key, col := range planned {
+		d.wide.columns[key] = col
+	}
+	return nil
+}
+
+// writeWide inserts rows pivoted by their timestamps and sources, in batches of up to batchSize wide rows;
+// inserts are idempotent, so on error none of the rows are reported as written
+func (conn *connection) writeWide(ex execer, d *destination, rows []row, retry retryPolicy) (int, error) {
+	d.wide.mu.Lock()
+	columns, wide := d.wide.pivot(rows)
+	d.wide.mu.Unlock()
+	perRow := len(columns) + 2
+	batchSize := conn.batchSize
+	if batchSize > maxPlaceholders/perRow {
+		batchSize = maxPlaceholders / perRow
+	}
+
+	for len(wide) > 0 {
+		n := len(wide)
+		if n > batchSize {
+			n = batchSize
+		}
+		args := make([]interface{}, 0, n*perRow)
+		for _, w := range wide[:n] {
+			args = append(args, w.timestamp, w.source)
+			for _, c := range columns {
+				args = append(args, w.values[c])
+			}
+		}
+		err := retry.do(func() error {
+			_, err := ex.Exec(wideInsertQuery(d.tableName, columns, n), args...)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		wide = wide[n:]
+	}
+	return len(rows), nil
+}
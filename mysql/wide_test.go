@@ -0,0 +1,153 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/intelsdi-x/snap/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func wideTestRow(ts time.Time, source string, data interface{}, ns ...string) row {
+	value, _ := interfaceToString(data)
+	return row{timestamp: ts, source: source, key: sliceToString(ns), value: value, data: data, namespace: core.NewNamespace(ns...)}
+}
+
+func TestWideTable(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 12, 0, 0, 0, time.UTC)
+
+	Convey("Wide layout", t, func() {
+		l, err := newLayout(schemaWide, tagsNone)
+		So(err, ShouldBeNil)
+		So(l.createQuery("db.info"), ShouldEqual, "CREATE TABLE IF NOT EXISTS db.info (timestamp DATETIME(6) NOT NULL, source_column VARCHAR(255) NOT NULL, PRIMARY KEY (timestamp, source_column))")
+	})
+
+	Convey("Columns of a wide table", t, func() {
+		w := &wideTable{
+			columns: map[string]wideColumn{"intel, mock, foo": {name: "intel/mock/foo", numeric: true}},
+			names:   map[string]bool{"timestamp": true, "source_column": true, "intel/mock/foo": true},
+		}
+
+		Convey("So names of columns should join the elements of namespaces", func() {
+			So(w.columnName("intel, mock, bar", []string{"intel", "mock", "bar"}), ShouldEqual, "intel/mock/bar")
+		})
+		Convey("So taken, long or invalid names should be suffixed with a hash of the namespace", func() {
+			name := w.columnName("Intel, mock, foo", []string{"Intel", "mock", "foo"})
+			So(name, ShouldStartWith, "Intel/mock/foo~")
+			So(name, ShouldHaveLength, len("Intel/mock/foo")+wideHashLength)
+			So(w.columnName("timestamp", []string{"timestamp"}), ShouldStartWith, "timestamp~")
+
+			long := strings.Repeat("element/", 10)
+			name = w.columnName(long, []string{long})
+			So(utf8.RuneCountInString(name), ShouldEqual, maxIdentifierLength)
+			So(name, ShouldNotEqual, w.columnName(long+"x", []string{long + "x"}))
+
+			So(w.columnName("a ", []string{"a "}), ShouldStartWith, "a~")
+		})
+		Convey("So new namespaces should be added with columns of the type of their values", func() {
+			clauses, planned := w.plan([]row{
+				wideTestRow(ts, "host", 1, "intel", "mock", "foo"),
+				wideTestRow(ts, "host", 2.5, "intel", "mock", "bar"),
+				wideTestRow(ts, "host", "up", "intel", "mock", "state"),
+				wideTestRow(ts, "host", 3, "intel", "mock", "bar"),
+			})
+			So(clauses, ShouldResemble, []string{
+				"ADD COLUMN `intel/mock/bar` DOUBLE NULL COMMENT 'intel, mock, bar'",
+				"ADD COLUMN `intel/mock/state` TEXT NULL COMMENT 'intel, mock, state'",
+			})
+			So(planned, ShouldResemble, map[string]wideColumn{
+				"intel, mock, bar":   {name: "intel/mock/bar", numeric: true},
+				"intel, mock, state": {name: "intel/mock/state"},
+			})
+		})
+		Convey("So numeric columns should be changed to text when they receive other values", func() {
+			clauses, planned := w.plan([]row{wideTestRow(ts, "host", "n/a", "intel", "mock", "foo")})
+			So(clauses, ShouldResemble, []string{"MODIFY `intel/mock/foo` TEXT NULL COMMENT 'intel, mock, foo'"})
+			So(planned["intel, mock, foo"], ShouldResemble, wideColumn{name: "intel/mock/foo"})
+		})
+		Convey("So known namespaces should need no changes", func() {
+			clauses, _ := w.plan([]row{wideTestRow(ts, "host", 7, "intel", "mock", "foo")})
+			So(clauses, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Pivot rows of a wide table", t, func() {
+		w := &wideTable{columns: map[string]wideColumn{
+			"intel, mock, foo":   {name: "intel/mock/foo", numeric: true},
+			"intel, mock, state": {name: "intel/mock/state"},
+		}}
+		later := ts.Add(time.Second)
+		columns, wide := w.pivot([]row{
+			wideTestRow(ts, "host1", 1, "intel", "mock", "foo"),
+			wideTestRow(ts, "host2", 2, "intel", "mock", "foo"),
+			wideTestRow(ts, "host1", "up", "intel", "mock", "state"),
+			wideTestRow(later, "host1", 3, "intel", "mock", "foo"),
+			wideTestRow(ts.In(time.FixedZone("CET", 3600)), "host1", 4, "intel", "mock", "foo"),
+		})
+
+		Convey("So metrics of a timestamp and source should share a row", func() {
+			So(columns, ShouldResemble, []string{"intel/mock/foo", "intel/mock/state"})
+			So(wide, ShouldHaveLength, 3)
+			So(wide[0].source, ShouldEqual, "host1")
+			So(wide[0].values, ShouldResemble, map[string]interface{}{"intel/mock/foo": float64(4), "intel/mock/state": "up"})
+			So(wide[1].values, ShouldResemble, map[string]interface{}{"intel/mock/foo": float64(2)})
+			So(wide[2].timestamp, ShouldResemble, later)
+		})
+	})
+
+	Convey("Share a wide table between concurrent publishes", t, func() {
+		d := &destination{tableName: "info", wide: &wideTable{columns: map[string]wideColumn{}, names: map[string]bool{}}}
+		conn := &connection{batchSize: 100}
+		ex := &countingExecer{}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rows := []row{wideTestRow(ts, "host1", i, "intel", "mock", string('a'+byte(i%5)))}
+				// columns are added the way addWideColumns does once the table is altered
+				d.wide.mu.Lock()
+				_, planned := d.wide.plan(rows)
+				for key, col := range planned {
+					d.wide.columns[key] = col
+				}
+				d.wide.mu.Unlock()
+				conn.writeWide(ex, d, rows, retryPolicy{})
+			}(i)
+		}
+		wg.Wait()
+
+		So(d.wide.columns, ShouldHaveLength, 5)
+		So(ex.execs, ShouldEqual, 20)
+	})
+
+	Convey("Insert into a wide table", t, func() {
+		So(wideInsertQuery("info", []string{"a/b", "c"}, 2), ShouldEqual, "INSERT INTO info (timestamp, source_column, `a/b`, `c`) VALUES ( ?, ?, ?, ? ), ( ?, ?, ?, ? ) "+
+			"ON DUPLICATE KEY UPDATE `a/b` = COALESCE(VALUES(`a/b`), `a/b`), `c` = COALESCE(VALUES(`c`), `c`)")
+		So(quoteString(`it's a\b`), ShouldEqual, `'it''s a\\b'`)
+	})
+}
@@ -20,7 +20,7 @@
 
 # Support travis.ci environment matrix:
 TEST_TYPE="${TEST_TYPE:-$1}"
-UNIT_TEST="${UNIT_TEST:-"gofmt goimports go_vet go_test go_cover"}"
+UNIT_TEST="${UNIT_TEST:-"gofmt goimports go_vet go_race go_test go_cover"}"
 TEST_K8S="${TEST_K8S:-0}"
 
 set -e